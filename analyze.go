@@ -0,0 +1,232 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"fmt"
+	"strings"
+)
+
+// Cycle is a directed cycle of hubs that can never make progress, found by
+// Analyze.  Each hub on it waits for all its sources, so a cycle with no
+// values on its pipes deadlocks, and one with every pipe full gridlocks.
+type Cycle struct {
+	Gridlock bool     // pipes full, otherwise a deadlock with pipes empty
+	Hubs     []string // dotted hub names in order of flow
+	Pipes    []string // pipe from each hub to the next
+	Tokens   int      // initial values on the pipes
+	Capacity int      // total capacity of the pipes
+}
+
+// String describes the cycle as hubs joined by pipes
+func (c Cycle) String() string {
+	var b strings.Builder
+	if c.Gridlock {
+		fmt.Fprintf(&b, "gridlock (%d values, capacity %d): ", c.Tokens, c.Capacity)
+	} else {
+		b.WriteString("deadlock (no initial values): ")
+	}
+	for i, h := range c.Hubs {
+		fmt.Fprintf(&b, "hub %q -> pipe %s -> ", h, c.Pipes[i])
+	}
+	fmt.Fprintf(&b, "hub %q", c.Hubs[0])
+	return b.String()
+}
+
+// Analyze looks for cycles of hubs in a flowgraph and every GraphHub inside
+// it that can never fire, before Run.  A cycle deadlocks if none of its
+// pipes has an initial value from Init or ConnectInit or is fed by more
+// than one upstream hub, and gridlocks if its initial values fill every
+// pipe.  Cycles through a hub that can fire without all its sources
+// (OneOf, Select, Wait, or Cross, as built by Loop) are not reported.
+// GraphHubs are analyzed separately, and are taken to need all their
+// sources for each of their results.  Returns nil if no cycle is found.
+func (fg *flowgraph) Analyze() []Cycle {
+	var cycles []Cycle
+	fg.analyze("", &cycles)
+	return cycles
+}
+
+// Analyze looks for cycles that can never fire inside a GraphHub
+func (gh *graphhub) Analyze() []Cycle {
+	var cycles []Cycle
+	gh.fg.(*flowgraph).analyze(gh.Name()+".", &cycles)
+	return cycles
+}
+
+// arc is a pipe from one hub to another, by index into fg.hubs
+type arc struct {
+	from, to int
+	p        Pipe
+}
+
+// analyze adds the cycles found in one flowgraph, and then in the body of
+// each GraphHub, with hub names prefixed
+func (fg *flowgraph) analyze(prefix string, cycles *[]Cycle) {
+	index := make(map[*fgbase.Node]int)
+	for i, h := range fg.hubs {
+		index[h.Base().(*fgbase.Node)] = i
+	}
+
+	var arcs []arc
+	seen := make(map[Pipe]bool)
+	for _, p := range fg.pipes {
+		if seen[p] || p.Empty() || p.IsConst() || p.IsSink() {
+			continue
+		}
+		seen[p] = true
+		for i := 0; i < p.NumUpstream(); i++ {
+			u, ok := fg.hubIndex(index, p.Upstream(i))
+			if !ok || !strict(fg.hubs[u]) {
+				continue
+			}
+			for j := 0; j < p.NumDownstream(); j++ {
+				d, ok := fg.hubIndex(index, p.Downstream(j))
+				if ok && strict(fg.hubs[d]) {
+					arcs = append(arcs, arc{u, d, p})
+				}
+			}
+		}
+	}
+
+	found := make(map[string]bool)
+	report := func(path []arc, gridlock bool) {
+		path = rotate(path)
+		key := cycleKey(path)
+		if found[key] {
+			return
+		}
+		found[key] = true
+		c := Cycle{Gridlock: gridlock}
+		for _, a := range path {
+			c.Hubs = append(c.Hubs, prefix+fg.hubs[a.from].Name())
+			c.Pipes = append(c.Pipes, pipeDesc(prefix, a.p))
+			if initial(a.p) {
+				c.Tokens++
+			}
+			c.Capacity += a.p.Capacity()
+		}
+		*cycles = append(*cycles, c)
+	}
+
+	// deadlock:  a cycle through pipes with no initial value, and none
+	// merged from another upstream hub that might feed it from outside
+	var empty []arc
+	for _, a := range arcs {
+		if !initial(a.p) && a.p.NumUpstream() == 1 {
+			empty = append(empty, a)
+		}
+	}
+	for _, a := range empty {
+		if path := shortestCycle(empty, a); path != nil {
+			report(path, false)
+		}
+	}
+
+	// gridlock:  a cycle with as many initial values as room in its pipes
+	for _, a := range arcs {
+		if !initial(a.p) {
+			continue
+		}
+		path := shortestCycle(arcs, a)
+		if path == nil {
+			continue
+		}
+		tokens, capacity := 0, 0
+		for _, b := range path {
+			if initial(b.p) {
+				tokens++
+			}
+			capacity += b.p.Capacity()
+		}
+		if tokens >= capacity {
+			report(path, true)
+		}
+	}
+
+	for _, h := range fg.hubs {
+		if gh, ok := h.(*graphhub); ok {
+			gh.fg.(*flowgraph).analyze(prefix+gh.Name()+".", cycles)
+		}
+	}
+}
+
+// hubIndex returns the index of a hub in this flowgraph
+func (fg *flowgraph) hubIndex(index map[*fgbase.Node]int, h Hub) (int, bool) {
+	if h == nil || h.Empty() {
+		return 0, false
+	}
+	i, ok := index[h.Base().(*fgbase.Node)]
+	return i, ok
+}
+
+// strict returns true if a hub waits for all its sources before firing
+func strict(h Hub) bool {
+	switch h.HubCode() {
+	case OneOf, Select, Wait, Cross:
+		return false
+	}
+	return true
+}
+
+// initial returns true if a pipe starts with a value from Init
+func initial(p Pipe) bool {
+	return p.Base().(*fgbase.Edge).Val != nil
+}
+
+// shortestCycle returns the shortest cycle of arcs that starts with a, or
+// nil if there is none
+func shortestCycle(arcs []arc, a arc) []arc {
+	prev := map[int]int{a.to: -1}
+	queue := []int{a.to}
+	for len(queue) > 0 && a.from != a.to {
+		h := queue[0]
+		queue = queue[1:]
+		for i, b := range arcs {
+			if b.from != h {
+				continue
+			}
+			if _, ok := prev[b.to]; ok {
+				continue
+			}
+			prev[b.to] = i
+			if b.to == a.from {
+				queue = nil
+				break
+			}
+			queue = append(queue, b.to)
+		}
+	}
+	if _, ok := prev[a.from]; !ok {
+		return nil
+	}
+	var back []arc
+	for h := a.from; h != a.to; h = arcs[prev[h]].from {
+		back = append(back, arcs[prev[h]])
+	}
+	path := []arc{a}
+	for i := len(back) - 1; i >= 0; i-- {
+		path = append(path, back[i])
+	}
+	return path
+}
+
+// rotate returns a cycle starting from the hub added first
+func rotate(path []arc) []arc {
+	first := 0
+	for i, a := range path {
+		if a.from < path[first].from {
+			first = i
+		}
+	}
+	return append(path[first:len(path):len(path)], path[:first]...)
+}
+
+// cycleKey identifies a rotated cycle by its arcs
+func cycleKey(path []arc) string {
+	var b strings.Builder
+	for _, a := range path {
+		fmt.Fprintf(&b, "%d>%p,", a.from, a.p.Base())
+	}
+	return b.String()
+}
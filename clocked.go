@@ -0,0 +1,46 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"sync/atomic"
+)
+
+// runClocked runs the nodes one clock cycle at a time.  Every node that
+// has not exited is checked for ready against the values on its pipes at
+// the start of the cycle, then every ready node fires once, in order of
+// flattening.  A value put in a cycle can be taken in the next, and room
+// given back in a cycle can be used in the next, so each pipe is a
+// register as deep as its capacity.  The run ends when every node has
+// exited, no node is ready, or after Options.MaxCycles.
+func (s *scheduler) runClocked(maxCycles int64) {
+	rdy := make([]*fgbase.Node, 0, len(s.nodes))
+	for !s.done() && !s.expired() {
+		if maxCycles > 0 && atomic.LoadInt64(&s.rs.cycles) >= maxCycles {
+			return
+		}
+
+		rdy = rdy[:0]
+		for _, n := range s.nodes {
+			if s.exited[n] {
+				continue
+			}
+			s.load(n)
+			if n.RdyAll() {
+				rdy = append(rdy, n)
+			}
+		}
+		if len(rdy) == 0 {
+			if s.idle() {
+				continue
+			}
+			return
+		}
+
+		c := atomic.AddInt64(&s.rs.cycles, 1)
+		for _, n := range rdy {
+			s.rs.tracef(n, "fired in cycle %d\n", c)
+			s.fire(n)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"fmt"
+	"time"
+)
+
+// ErrorCode says what a hub does when its Retriever, Transmitter, or
+// Transformer returns an error.  Errors not put on a result port are
+// collected and returned from Run or RunContext with the name of the hub.
+type ErrorCode int
+
+const (
+	SkipOnError   ErrorCode = iota // drop the token and keep going
+	FailOnError                    // stop the whole flowgraph
+	RetryOnError                   // retry with backoff, then stop the whole flowgraph
+	ResultOnError                  // put the error on the ErrorPort result of the hub
+)
+
+// ErrorPort is the name of the result port a hub with ResultOnError puts
+// its errors on, and nothing else
+const ErrorPort = "error"
+
+// String method for ErrorCode
+func (c ErrorCode) String() string {
+	return []string{
+		"SkipOnError",
+		"FailOnError",
+		"RetryOnError",
+		"ResultOnError",
+	}[c]
+}
+
+// ErrorPolicy is set per hub with Hub.SetErrorPolicy, or per flowgraph with
+// Flowgraph.SetDefaultErrorPolicy.  The default is SkipOnError.
+type ErrorPolicy struct {
+	Code    ErrorCode
+	Retries int           // number of retries for RetryOnError
+	Backoff time.Duration // wait before the first retry, doubled for each one after
+}
+
+// errorPolicy returns the policy for a node of this flowgraph
+func (fg *flowgraph) errorPolicy(n *fgbase.Node) ErrorPolicy {
+	if p, ok := fg.policies[n]; ok {
+		return p
+	}
+	if fg.policy != nil {
+		return *fg.policy
+	}
+	if rs := fg.rs.Load(); rs != nil && rs.policy != nil {
+		return *rs.policy
+	}
+	return ErrorPolicy{}
+}
+
+// callUser calls f, the user code of hub n, under the error policy of the hub.
+// Returns nil or EOS if the results of f are to be used, otherwise the error
+// already handled by the policy.
+func (fg *flowgraph) callUser(n *fgbase.Node, f func() error) error {
+	if rs := fg.rs.Load(); rs != nil {
+		if hs := rs.hstats[n]; hs != nil {
+			user := f
+			f = func() error {
+				start := time.Now()
+				defer func() { hs.addUser(time.Since(start)) }()
+				return user()
+			}
+		}
+	}
+	err := f()
+	if err == nil || isEOS(err) {
+		return err
+	}
+
+	p := fg.errorPolicy(n)
+	if p.Code == RetryOnError {
+		d := p.Backoff
+		for i := 0; i < p.Retries && err != nil && !isEOS(err); i++ {
+			time.Sleep(d)
+			d *= 2
+			err = f()
+		}
+		if err == nil || isEOS(err) {
+			return err
+		}
+	}
+	return fg.policyError(n, p, err)
+}
+
+// hubError handles an error found by hub n itself, such as a value it
+// cannot use, under the error policy of the hub.  Retrying cannot help,
+// so RetryOnError stops the flowgraph as FailOnError does.
+func (fg *flowgraph) hubError(n *fgbase.Node, err error) error {
+	return fg.policyError(n, fg.errorPolicy(n), err)
+}
+
+// policyError puts an error of hub n on a result, or collects it and stops
+// the flowgraph, as policy p says.  Returns the error with the hub name.
+func (fg *flowgraph) policyError(n *fgbase.Node, p ErrorPolicy, err error) error {
+	herr := fmt.Errorf("hub %q: %w", n.Name, err)
+	if i, ok := n.FindDstIndex(ErrorPort); ok && p.Code == ResultOnError {
+		n.Dsts[i].DstPut(herr)
+		return herr
+	}
+	n.LogError("%s\n", err)
+	if p.Code == FailOnError || p.Code == RetryOnError {
+		fg.rs.Load().fail(herr)
+	} else {
+		fg.rs.Load().record(herr)
+	}
+	return herr
+}
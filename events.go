@@ -0,0 +1,219 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// traceEvent is one event of the Chrome Trace Event format
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   float64                `json:"ts"`
+	Pid  int                    `json:"pid"`
+	Tid  int64                  `json:"tid"`
+	ID   int64                  `json:"id,omitempty"`
+	Bp   string                 `json:"bp,omitempty"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// eventRecorder writes every firing of a run as it happens, as a begin and
+// an end event on the goroutine that first fired the hub.  Each value on a
+// pipe gets a token number, counted from the initial value if any, and a
+// flow event from the firing that put it to each firing that took it.
+type eventRecorder struct {
+	mu     sync.Mutex
+	start  time.Time
+	w      *bufio.Writer
+	n      int   // events written
+	err    error // first error writing
+	closed bool
+	tids   map[*fgbase.Node]int64
+	hubs   map[*fgbase.Node]string
+	pipes  map[pipeKey]string
+	index  map[pipeKey]int64
+	puts   map[pipeKey]int64
+	gets   map[getKey]int64
+}
+
+// getKey is a pipe as taken from by one downstream node
+type getKey struct {
+	k pipeKey
+	n *fgbase.Node
+}
+
+// newEventRecorder readies a recorder for the nodes of a run of fg, and
+// starts the JSON written to w
+func (fg *flowgraph) newEventRecorder(nodes []*fgbase.Node, w io.Writer) *eventRecorder {
+	er := &eventRecorder{
+		start: time.Now(),
+		w:     bufio.NewWriter(w),
+		tids:  make(map[*fgbase.Node]int64),
+		hubs:  fg.hubNames(),
+		pipes: make(map[pipeKey]string),
+		index: make(map[pipeKey]int64),
+		puts:  make(map[pipeKey]int64),
+		gets:  make(map[getKey]int64),
+	}
+	fg.walkPipeKeys(func(path string, k pipeKey) {
+		er.pipes[k] = path
+	})
+	for _, n := range nodes {
+		for i, e := range n.Dsts {
+			if e == nil || e.IsSink() {
+				continue
+			}
+			k := pipeKey{n, i}
+			er.index[k] = int64(len(er.index) + 1)
+			if e.Val != nil {
+				er.puts[k] = 1 // initial value is token 0
+			}
+		}
+	}
+	_, er.err = er.w.WriteString(`{"traceEvents":[`)
+	return er
+}
+
+// fired records a firing of n from begin until now
+func (er *eventRecorder) fired(n *fgbase.Node, begin time.Time) {
+	end := time.Now()
+
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	if er.closed || er.err != nil {
+		return
+	}
+	tid, ok := er.tids[n]
+	if !ok {
+		tid = goid()
+		er.tids[n] = tid
+	}
+
+	name := er.hubs[n]
+	cat := ""
+	if h, ok := n.Owner.(Hub); ok {
+		cat = h.HubCode().String()
+	}
+	sources := make(map[string]interface{})
+	results := make(map[string]interface{})
+	var flows []traceEvent
+
+	for _, e := range n.Srcs {
+		if e == nil || !e.Flow || e.IsConst() {
+			continue
+		}
+		k, ok := keyOf(e)
+		if !ok {
+			continue
+		}
+		g := getKey{k, n}
+		tok := er.gets[g]
+		er.gets[g]++
+		sources[er.pipeName(k)] = tok
+		flows = append(flows, traceEvent{Name: er.pipeName(k), Cat: "token", Ph: "f", Bp: "e",
+			Ts: er.ts(begin), Pid: 1, Tid: tid, ID: er.flowID(k, tok)})
+	}
+	for i, e := range n.Dsts {
+		if e == nil || e.Val == nil {
+			continue
+		}
+		k := pipeKey{n, i}
+		if _, ok := er.index[k]; !ok {
+			continue
+		}
+		tok := er.puts[k]
+		er.puts[k]++
+		results[er.pipeName(k)] = tok
+		flows = append(flows, traceEvent{Name: er.pipeName(k), Cat: "token", Ph: "s",
+			Ts: er.ts(end), Pid: 1, Tid: tid, ID: er.flowID(k, tok)})
+	}
+
+	args := make(map[string]interface{})
+	if len(sources) > 0 {
+		args["sources"] = sources
+	}
+	if len(results) > 0 {
+		args["results"] = results
+	}
+	er.emit(traceEvent{Name: name, Cat: cat, Ph: "B", Ts: er.ts(begin), Pid: 1, Tid: tid, Args: args})
+	er.emit(traceEvent{Name: name, Cat: cat, Ph: "E", Ts: er.ts(end), Pid: 1, Tid: tid})
+	for _, f := range flows {
+		er.emit(f)
+	}
+}
+
+// emit writes one event, keeping the first error
+func (er *eventRecorder) emit(ev traceEvent) {
+	if er.err != nil {
+		return
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		er.err = err
+		return
+	}
+	if er.n > 0 {
+		er.w.WriteByte(',')
+	}
+	_, er.err = er.w.Write(b)
+	er.n++
+}
+
+// pipeName names a pipe, by its upstream hub and port if not known
+func (er *eventRecorder) pipeName(k pipeKey) string {
+	if name, ok := er.pipes[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("%s[%d]", k.n.Name, k.i)
+}
+
+// flowID numbers a token on a pipe uniquely across the run
+func (er *eventRecorder) flowID(k pipeKey, tok int64) int64 {
+	return er.index[k]<<32 | tok
+}
+
+// ts is the time of an event in microseconds from the start of the run
+func (er *eventRecorder) ts(t time.Time) float64 {
+	return float64(t.Sub(er.start).Nanoseconds()) / 1e3
+}
+
+// close ends the Chrome Trace Event JSON, for chrome://tracing or
+// Perfetto, and returns the first error writing it.  Later firings are
+// not written.
+func (er *eventRecorder) close() error {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	if er.closed {
+		return nil
+	}
+	er.closed = true
+	if er.err == nil {
+		_, er.err = er.w.WriteString(`],"displayTimeUnit":"ns"}`)
+	}
+	if er.err == nil {
+		er.err = er.w.Flush()
+	}
+	return er.err
+}
+
+// goid returns the id of the current goroutine
+func goid() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
@@ -17,24 +17,14 @@ import (
 	"time"
 )
 
-type randOne struct{}
-
-func (r *randOne) Retrieve(hub flowgraph.Hub) (result interface{}, err error) {
+var randOne = flowgraph.RetrieverFunc[float64](func(hub flowgraph.Hub) (float64, error) {
 	return rand.Float64(), nil
-}
-
-type checkSumSquare struct{}
+})
 
-func (e *checkSumSquare) Transform(n flowgraph.Hub, source []interface{}) (
-	result []interface{}, err error) {
-
-	result = make([]interface{}, 1)
-	a := source[0].(float64)
-	b := source[1].(float64)
+var checkSumSquare = flowgraph.Func2[float64, float64, bool](func(n flowgraph.Hub, a, b float64) (bool, error) {
 	x := a*a + b*b
-	result[0] = x <= 1.0
-	return
-}
+	return x <= 1.0, nil
+})
 
 type piCalc struct {
 	cnt int
@@ -71,13 +61,13 @@ func main() {
 
 	fg := flowgraph.New("calcpi")
 
-	randA := fg.NewHub("randA", flowgraph.Retrieve, &randOne{}).
+	randA := fg.NewHub("randA", flowgraph.Retrieve, randOne).
 		SetResultNames("X")
 
-	randB := fg.NewHub("randB", flowgraph.Retrieve, &randOne{}).
+	randB := fg.NewHub("randB", flowgraph.Retrieve, randOne).
 		SetResultNames("X")
 
-	check := fg.NewHub("check", flowgraph.AllOf, &checkSumSquare{}).
+	check := fg.NewHub("check", flowgraph.AllOf, checkSumSquare).
 		SetSourceNames("A", "B").
 		SetResultNames("X")
 
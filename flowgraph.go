@@ -6,10 +6,13 @@ package flowgraph
 import (
 	"github.com/vectaport/fgbase"
 
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"strings"
+	"sync/atomic"
 )
 
 // EOS is flowgraph's own name for fgbase.EOS -- the same value, not a
@@ -21,11 +24,13 @@ var EOS = fgbase.EOS
 
 var flatDot = false
 
-// ParseFlags parses the command line flags for this package
-func ParseFlags() {
+// ParseFlags parses the command line flags for this package into the
+// fgbase package globals, and returns them as Options as well
+func ParseFlags() Options {
 	flag.BoolVar(&flatDot, "flatdot", false, "flatten dot output")
 	fgbase.ConfigByFlag(map[string]interface{}{"trace": "V"})
 	fgbase.TraceStyle = fgbase.New
+	return DefaultOptions()
 }
 
 // Flowgraph interface for flowgraphs assembled out of hubs and pipes
@@ -49,18 +54,34 @@ type Flowgraph interface {
 	// NewHub returns a new unconnected hub
 	NewHub(name string, code HubCode, init interface{}) Hub
 
+	// TryNewHub is NewHub returning an error instead of panicking
+	TryNewHub(name string, code HubCode, init interface{}) (Hub, error)
+
 	// NewPipe returns a new unconnected pipe
 	NewPipe(name string) Pipe
 
 	// NewGraphHub returns a hub with a flowgraph inside
 	NewGraphHub(name string, code HubCode) GraphHub
 
-	// FindHub finds a hub by name
+	// TryNewGraphHub is NewGraphHub returning an error instead of panicking
+	TryNewGraphHub(name string, code HubCode) (GraphHub, error)
+
+	// FindHub finds a hub by name, or by a dotted path of GraphHub names
+	// ending in a hub name, like "while1.while2.sub"
 	FindHub(name string) Hub
 
-	// FindPipe finds a pipe by name
+	// FindPipe finds a pipe by name, or by a dotted path of GraphHub names
+	// ending in a pipe name
 	FindPipe(name string) Pipe
 
+	// WalkHubs calls f for every hub, with its dotted path, descending into
+	// each GraphHub after calling f for it.  Stops when f returns false.
+	WalkHubs(f func(path string, h Hub) bool)
+
+	// WalkPipes calls f once for every pipe, with its dotted path,
+	// including those inside each GraphHub.  Stops when f returns false.
+	WalkPipes(f func(path string, p Pipe) bool)
+
 	// Connect connects two hubs via named (string) or indexed (int) ports
 	Connect(
 		upstream Hub, upstreamPort interface{},
@@ -73,23 +94,80 @@ type Flowgraph interface {
 		dnstream Hub, dnstreamPort interface{},
 		init interface{}) Pipe
 
-	// Run runs the flowgraph
-	Run()
+	// TryConnect is Connect returning an error instead of panicking.
+	// Connecting two ports already connected to other pipes is an error.
+	TryConnect(
+		upstream Hub, upstreamPort interface{},
+		dnstream Hub, dnstreamPort interface{}) (Pipe, error)
+
+	// TryConnectInit is ConnectInit returning an error instead of panicking
+	TryConnectInit(
+		upstream Hub, upstreamPort interface{},
+		dnstream Hub, dnstreamPort interface{},
+		init interface{}) (Pipe, error)
+
+	// SetDefaultErrorPolicy sets the error policy for hubs without their
+	// own.  ResultOnError is set per hub instead.
+	SetDefaultErrorPolicy(p ErrorPolicy)
+
+	// Validate checks the wiring of the flowgraph and every GraphHub inside
+	// it before Run.  Returns every problem found joined together, or nil.
+	Validate() error
+
+	// Analyze looks for cycles of hubs in the flowgraph and every GraphHub
+	// inside it that deadlock or gridlock, before Run.  Returns nil if none.
+	Analyze() []Cycle
+
+	// Stats returns the runtime metrics of every hub and pipe of the
+	// flowgraph and every GraphHub inside it, during or after Run
+	Stats() Stats
+
+	// Options returns the options of the flowgraph, DefaultOptions if
+	// never set
+	Options() Options
+
+	// SetOptions configures the flowgraph and every GraphHub inside it,
+	// in place of the fgbase package globals
+	SetOptions(o Options)
+
+	// Run runs the flowgraph.  Returns the hub errors joined together.
+	Run() error
+
+	// RunContext runs the flowgraph until every hub has exited.  When ctx
+	// is done every hub is drained with EOS, even one waiting on a source
+	// that will never get a value, and RunContext returns once they have
+	// exited.  Values already in a While or During loop come out of it
+	// first, so a loop that never lets a value out keeps RunContext from
+	// returning.  Returns the hub errors joined together, otherwise the
+	// context error if ctx ended the run.
+	RunContext(ctx context.Context) error
 }
 
 type flowgraph struct {
-	title      string
-	hubs       []Hub
-	pipes      []Pipe
-	nameToHub  map[string]Hub
-	nameToPipe map[string]Pipe
+	title       string
+	hubs        []Hub
+	pipes       []Pipe
+	nameToHub   map[string]Hub
+	nameToPipe  map[string]Pipe
+	policies    map[*fgbase.Node]ErrorPolicy
+	policy      *ErrorPolicy
+	rs          atomic.Pointer[runState]
+	ptypes      []pipeType
+	htypes      map[*fgbase.Node]portTypes
+	connectErrs []connectErr
+	opts        *Options
+	pcaps       []pipeCap
+	parallel    map[*fgbase.Node]int
+	replicas    map[*fgbase.Node][]*fgbase.Node
 }
 
 // New returns a titled flowgraph
 func New(title string) Flowgraph {
 	nameToHub := make(map[string]Hub)
 	nameToPipe := make(map[string]Pipe)
-	fg := flowgraph{title, nil, nil, nameToHub, nameToPipe}
+	policies := make(map[*fgbase.Node]ErrorPolicy)
+	htypes := make(map[*fgbase.Node]portTypes)
+	fg := flowgraph{title, nil, nil, nameToHub, nameToPipe, policies, nil, atomic.Pointer[runState]{}, nil, htypes, nil, nil, nil, nil, nil}
 	return &fg
 }
 
@@ -148,36 +226,31 @@ func (f *fgTransmitter) String() string {
 // NewHub returns a new unconnected hub
 func (fg *flowgraph) NewHub(name string, code HubCode, init interface{}) Hub {
 
+	if err := initError(code, init); err != nil {
+		panic(err.Error())
+	}
+	name = fg.uniqueHubName(name)
+
 	var n fgbase.Node
 
+	typer, typed := init.(portTyper)
+
 	switch code {
 
 	// User Hubs
 	case Retrieve:
-		if _, ok := init.(Retriever); !ok {
-			panic(fmt.Sprintf("Hub with Retrieve code not given Retriever for init %T(%+v)", init, init))
-		}
 		n = fgbase.MakeNode(name, nil, nil, retrieveRdy, retrieveFire)
 		init = &fgRetriever{fg, init.(Retriever)}
 
 	case Transmit:
-		if _, ok := init.(Transmitter); !ok {
-			panic(fmt.Sprintf("Hub with Transmit code not given Transmitter for init %T(%+v)", init, init))
-		}
 		n = fgbase.MakeNode(name, nil, nil, nil, transmitFire)
 		init = &fgTransmitter{fg, init.(Transmitter)}
 
 	case AllOf:
-		if _, ok := init.(Transformer); !ok {
-			panic(fmt.Sprintf("Hub with AllOf code not given Transformer for init %T(%+v)", init, init))
-		}
 		n = fgbase.MakeNode(name, nil, nil, nil, allOfFire)
 		init = &fgTransformer{fg, init.(Transformer)}
 
 	case OneOf:
-		if _, ok := init.(Transformer); !ok {
-			panic(fmt.Sprintf("Hub with OneOf code not given Transformer for init %T(%+v)", init, init))
-		}
 		n = fgbase.MakeNode(name, nil, nil, oneOfRdy, oneOfFire)
 		init = &fgTransformer{fg, init.(Transformer)}
 
@@ -189,7 +262,7 @@ func (fg *flowgraph) NewHub(name string, code HubCode, init interface{}) Hub {
 		n = fgbase.MakeNode(name, nil, nil, waitRdy, waitFire)
 
 	case Select:
-		n = fgbase.MakeNode(name, nil, nil, fgbase.SteervRdy, selectFire)
+		n = fgbase.MakeNode(name, nil, []*fgbase.Edge{nil}, selectRdy, selectFire)
 
 	case Steer:
 		n = fgbase.MakeNode(name, nil, nil, fgbase.SteervRdy, fgbase.SteervFire)
@@ -208,17 +281,12 @@ func (fg *flowgraph) NewHub(name string, code HubCode, init interface{}) Hub {
 		n = fgbase.MakeNode(name, []*fgbase.Edge{nil}, []*fgbase.Edge{nil}, nil, nil)
 
 	case Split:
-		n = fgbase.MakeNode(name, nil, []*fgbase.Edge{nil}, nil, splitFire)
+		n = fgbase.MakeNode(name, []*fgbase.Edge{nil}, nil, nil, splitFire)
 
 	case Join:
 		n = fgbase.MakeNode(name, nil, []*fgbase.Edge{nil}, nil, joinFire)
 
 	case Sink:
-		if init != nil {
-			if _, ok := init.(Sinker); !ok {
-				panic(fmt.Sprintf("Hub with Sink code not given Sinker for init %T(%+v)", init, init))
-			}
-		}
 		n = fgbase.MakeNode(name, []*fgbase.Edge{nil}, nil, nil, fgbase.SinkFire)
 
 	// Math and Logic Hubs
@@ -238,42 +306,103 @@ func (fg *flowgraph) NewHub(name string, code HubCode, init interface{}) Hub {
 		n = fgbase.MakeNode(name, []*fgbase.Edge{nil, nil}, []*fgbase.Edge{nil}, nil, fgbase.ModFire)
 
 	case And:
+		init = logicInit(fg, code, init)
 		n = fgbase.MakeNode(name, []*fgbase.Edge{nil, nil}, []*fgbase.Edge{nil}, nil, andFire)
 
 	case Or:
+		init = logicInit(fg, code, init)
 		n = fgbase.MakeNode(name, []*fgbase.Edge{nil, nil}, []*fgbase.Edge{nil}, nil, orFire)
 
 	case Not:
+		init = logicInit(fg, code, init)
 		n = fgbase.MakeNode(name, []*fgbase.Edge{nil}, []*fgbase.Edge{nil}, nil, notFire)
 
 	case Shift:
+		init = logicInit(fg, code, init)
 		n = fgbase.MakeNode(name, []*fgbase.Edge{nil, nil}, []*fgbase.Edge{nil}, nil, shiftFire)
 
-	default:
-		log.Panicf("Unexpected Hub code NewHub:  %s\n", code)
 	}
 	if n.Aux == nil {
 		n.Aux = init
 	}
 
+	if typed {
+		sources, results := typer.portTypes()
+		fg.htypes[&n] = portTypes{sources, results}
+	}
+
 	h := &hub{&n, fg, code}
 	n.Owner = h
 	fg.hubs = append(fg.hubs, h)
-	fg.nameToHub[name] = h
+	fg.nameToHub[n.Name] = h
 	return h
 }
 
+// TryNewHub is NewHub returning an error instead of panicking
+func (fg *flowgraph) TryNewHub(name string, code HubCode, init interface{}) (Hub, error) {
+	if err := initError(code, init); err != nil {
+		return nil, err
+	}
+	return fg.NewHub(name, code, init), nil
+}
+
+// initError returns an error if init is not the right kind of init arg
+// for NewHub with code
+func initError(code HubCode, init interface{}) error {
+	var ok bool
+	switch code {
+	case Retrieve:
+		_, ok = init.(Retriever)
+		return notGiven(ok, code, "Retriever", init)
+	case Transmit:
+		_, ok = init.(Transmitter)
+		return notGiven(ok, code, "Transmitter", init)
+	case AllOf, OneOf:
+		_, ok = init.(Transformer)
+		return notGiven(ok, code, "Transformer", init)
+	case Sink:
+		_, ok = init.(Sinker)
+		return notGiven(ok || init == nil, code, "Sinker", init)
+	case Shift:
+		_, ok = init.(ShiftCode)
+		_, tok := init.(Transformer)
+		return notGiven(ok || tok || init == nil, code, "ShiftCode or Transformer", init)
+	case And, Or, Not:
+		_, ok = init.(Transformer)
+		return notGiven(ok || init == nil, code, "Transformer", init)
+	case Wait, Select, Steer, Cross, Array, Constant, Pass, Split, Join,
+		Add, Subtract, Multiply, Divide, Modulo:
+		return nil
+	}
+	return fmt.Errorf("Unexpected Hub code NewHub:  %s", code)
+}
+
+// notGiven returns an error for a hub not given the init arg it needs
+func notGiven(ok bool, code HubCode, what string, init interface{}) error {
+	if ok {
+		return nil
+	}
+	return fmt.Errorf("Hub with %s code not given %s for init %T(%+v)", code, what, init, init)
+}
+
 // NewPipe returns a new unconnected pipe
 func (fg *flowgraph) NewPipe(name string) Pipe {
+	if name != "" {
+		name = fg.uniquePipeName(name)
+	}
 	e := fgbase.MakeEdge(name, nil)
+	e.RdyCnt = fg.channelSize()
 	s := &pipe{&e, fg}
 	fg.pipes = append(fg.pipes, s)
-	fg.nameToPipe[name] = s
+	if name != "" {
+		fg.nameToPipe[name] = s
+	}
 	return s
 }
 
 // NewGraphHub returns a hub with an internal flowgraph
 func (fg *flowgraph) NewGraphHub(name string, code HubCode) GraphHub {
+	name = fg.uniqueHubName(name)
 	newfg := New(name + "_fg")
 
 	var n fgbase.Node
@@ -288,23 +417,123 @@ func (fg *flowgraph) NewGraphHub(name string, code HubCode) GraphHub {
 	default:
 		log.Panicf("Unexpected HubCode for NewGraphHub:  %v\n", code)
 	}
-	gh := &graphhub{&hub{&n, fg, code}, newfg, nil, nil}
+	if fg.opts != nil {
+		newfg.SetOptions(*fg.opts)
+	}
+	gh := &graphhub{&hub{&n, fg, code}, newfg, nil, nil, 0}
 	n.Owner = gh
 	fg.hubs = append(fg.hubs, gh)
-	fg.nameToHub[name] = gh
+	fg.nameToHub[n.Name] = gh
 	return gh
 }
 
-// FindHub finds a hub by name
+// TryNewGraphHub is NewGraphHub returning an error instead of panicking
+func (fg *flowgraph) TryNewGraphHub(name string, code HubCode) (GraphHub, error) {
+	if code != While && code != During && code != Graph {
+		return nil, fmt.Errorf("Unexpected HubCode for NewGraphHub:  %v", code)
+	}
+	return fg.NewGraphHub(name, code), nil
+}
+
+// FindHub finds a hub by name, or by a dotted path of GraphHub names
+// ending in a hub name, like "while1.while2.sub"
 func (fg *flowgraph) FindHub(name string) Hub {
+	if gh, rest := fg.findGraphHub(name); gh != nil {
+		return gh.FindHub(rest)
+	}
 	return fg.nameToHub[name]
 }
 
-// FindPipe finds a Pipe by name
+// FindPipe finds a Pipe by name, or by a dotted path of GraphHub names
+// ending in a pipe name
 func (fg *flowgraph) FindPipe(name string) Pipe {
+	if gh, rest := fg.findGraphHub(name); gh != nil {
+		return gh.FindPipe(rest)
+	}
 	return fg.nameToPipe[name]
 }
 
+// findGraphHub returns the GraphHub named by the first element of a dotted
+// path, and the rest of the path
+func (fg *flowgraph) findGraphHub(path string) (GraphHub, string) {
+	i := strings.Index(path, ".")
+	if i < 0 {
+		return nil, ""
+	}
+	gh, _ := fg.nameToHub[path[:i]].(GraphHub)
+	if gh == nil {
+		return nil, ""
+	}
+	return gh, path[i+1:]
+}
+
+// WalkHubs calls f for every hub, with its dotted path, descending into
+// each GraphHub after calling f for it.  Stops when f returns false.
+func (fg *flowgraph) WalkHubs(f func(path string, h Hub) bool) {
+	fg.walkHubs("", f)
+}
+
+func (fg *flowgraph) walkHubs(prefix string, f func(path string, h Hub) bool) bool {
+	for _, h := range fg.hubs {
+		path := prefix + h.Name()
+		if !f(path, h) {
+			return false
+		}
+		if gh, ok := h.(*graphhub); ok && !gh.fg.(*flowgraph).walkHubs(path+".", f) {
+			return false
+		}
+	}
+	return true
+}
+
+// WalkPipes calls f once for every pipe, with its dotted path, including
+// those inside each GraphHub.  Stops when f returns false.
+func (fg *flowgraph) WalkPipes(f func(path string, p Pipe) bool) {
+	fg.walkPipes("", f)
+}
+
+func (fg *flowgraph) walkPipes(prefix string, f func(path string, p Pipe) bool) bool {
+	seen := make(map[Pipe]bool) // a pipe made by Connect is listed twice
+	for _, p := range fg.pipes {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		if !f(prefix+p.Name(), p) {
+			return false
+		}
+	}
+	for _, h := range fg.hubs {
+		if gh, ok := h.(*graphhub); ok && !gh.fg.(*flowgraph).walkPipes(prefix+gh.Name()+".", f) {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueHubName returns name, suffixed if already taken by another hub
+func (fg *flowgraph) uniqueHubName(name string) string {
+	return uniqueName(name, func(nm string) bool { return fg.nameToHub[nm] != nil })
+}
+
+// uniquePipeName returns name, suffixed if already taken by another pipe
+func (fg *flowgraph) uniquePipeName(name string) string {
+	return uniqueName(name, func(nm string) bool { return fg.nameToPipe[nm] != nil })
+}
+
+// uniqueName returns name if not taken, otherwise name with the first
+// suffix of _1, _2, and so on that is not taken
+func uniqueName(name string, taken func(name string) bool) string {
+	if !taken(name) {
+		return name
+	}
+	for i := 1; ; i++ {
+		if nm := fmt.Sprintf("%s_%d", name, i); !taken(nm) {
+			return nm
+		}
+	}
+}
+
 // Connect connects two hubs via named (string) or indexed (int) ports
 func (fg *flowgraph) Connect(
 	upstream Hub, upstreamPort interface{},
@@ -328,43 +557,12 @@ func (fg *flowgraph) connectInit(
 	dnstream Hub, dnstreamPort interface{},
 	init interface{}) Pipe {
 
-	checkInternalHub(fg, upstream)
-	checkInternalHub(fg, dnstream)
-
-	var us Pipe
-	var usok bool
-	switch v := upstreamPort.(type) {
-	case string:
-		us, usok = upstream.Result(v), !upstream.Empty()
-		if !usok {
-			upstream.Panicf("No result port \"%s\" found on Hub \"%s\"\n", v, upstream.Name())
-		}
-	case int:
-		usok = v >= 0 && v < upstream.NumResult()
-		if !usok {
-			upstream.Panicf("No result port %d found on Hub \"%s\"\n", v, upstream.Name())
-		}
-		us = upstream.Result(v)
-	default:
-		upstream.Panicf("Need string or int to specify port on upstream Hub \"%s\"\n", upstream.Name())
-	}
-
-	var ds Pipe
-	var dsok bool
-	switch v := dnstreamPort.(type) {
-	case string:
-		ds, dsok = dnstream.Source(v), !dnstream.Empty()
-		if !dsok {
-			dnstream.Panicf("No source port \"%s\" found on Hub \"%s\"\n", v, dnstream.Name())
+	us, ds, bad, err := fg.connectPorts(upstream, upstreamPort, dnstream, dnstreamPort)
+	if err != nil {
+		if bad == nil {
+			panic(err.Error())
 		}
-	case int:
-		dsok = v >= 0 && v < dnstream.NumSource()
-		if !dsok {
-			upstream.Panicf("No source port %d found on Hub \"%s\"\n", v, dnstream.Name())
-		}
-		ds = dnstream.Source(v)
-	default:
-		dnstream.Panicf("Need string or int to specify port on downstream Hub \"%s\"\n", dnstream.Name())
+		bad.Panicf("%v\n", err)
 	}
 
 	if us.Empty() && ds.Empty() {
@@ -383,29 +581,143 @@ func (fg *flowgraph) connectInit(
 		dnstream.SetSource(dnstreamPort, us)
 		return us
 	}
-	/*
-		upstream.Panicf("Unexpected that with two ports to connect (%s:%v(%s) and %s:%v(%s() that they both are already connected to another pipe as well",
-			upstream.Name(), upstreamPort, us.Name(), dnstream.Name(), dnstreamPort, ds.Name())
-	*/
+	fg.connectErrs = append(fg.connectErrs, connectErr{upstream, upstreamPort, dnstream, dnstreamPort})
 	return nil
 }
 
-// Run runs the flowgraph
-func (fg *flowgraph) Run() {
-	fg.run()
+// TryConnect is Connect returning an error instead of panicking.
+// Connecting two ports already connected to other pipes is an error.
+func (fg *flowgraph) TryConnect(
+	upstream Hub, upstreamPort interface{},
+	dnstream Hub, dnstreamPort interface{}) (Pipe, error) {
+	return fg.TryConnectInit(upstream, upstreamPort, dnstream, dnstreamPort, nil)
+}
+
+// TryConnectInit is ConnectInit returning an error instead of panicking
+func (fg *flowgraph) TryConnectInit(
+	upstream Hub, upstreamPort interface{},
+	dnstream Hub, dnstreamPort interface{},
+	init interface{}) (Pipe, error) {
+
+	us, ds, _, err := fg.connectPorts(upstream, upstreamPort, dnstream, dnstreamPort)
+	if err != nil {
+		return nil, err
+	}
+
+	ui, di := upstream.ResultIndex(upstreamPort), dnstream.SourceIndex(dnstreamPort)
+	switch {
+	case us.Empty() && ds.Empty():
+		rt := fg.portType(upstream.Base().(*fgbase.Node), ui, false)
+		st := fg.portType(dnstream.Base().(*fgbase.Node), di, true)
+		if rt != nil && st != nil && !rt.AssignableTo(st) {
+			err = fmt.Errorf("Result port %d of type %s on Hub %q can not connect to source port %d of type %s on Hub %q",
+				ui, rt, upstream.Name(), di, st, dnstream.Name())
+		}
+	case us.Empty():
+		err = fg.resultTypeError(upstream, ui, ds)
+	case ds.Empty():
+		err = fg.sourceTypeError(dnstream, di, us)
+	default:
+		err = errors.New(connectErr{upstream, upstreamPort, dnstream, dnstreamPort}.message(""))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fg.connectInit(upstream, upstreamPort, dnstream, dnstreamPort, init), nil
+}
+
+// connectPorts returns the pipes on two ports to connect, or an error and
+// the hub to blame if any
+func (fg *flowgraph) connectPorts(
+	upstream Hub, upstreamPort interface{},
+	dnstream Hub, dnstreamPort interface{}) (us, ds Pipe, bad Hub, err error) {
+
+	if upstream == nil {
+		return nil, nil, nil, errors.New("Need upstream Hub to connect, got nil")
+	}
+	if dnstream == nil {
+		return nil, nil, nil, errors.New("Need downstream Hub to connect, got nil")
+	}
+	if err = internalHubError(fg, upstream); err != nil {
+		return nil, nil, nil, err
+	}
+	if err = internalHubError(fg, dnstream); err != nil {
+		return nil, nil, nil, err
+	}
+
+	switch v := upstreamPort.(type) {
+	case string:
+		if us, err = upstream.TryResult(v); err != nil {
+			return nil, nil, upstream, fmt.Errorf("No result port \"%s\" found on Hub \"%s\"", v, upstream.Name())
+		}
+	case int:
+		if v < 0 || v >= upstream.NumResult() {
+			return nil, nil, upstream, fmt.Errorf("No result port %d found on Hub \"%s\"", v, upstream.Name())
+		}
+		us = upstream.Result(v)
+	default:
+		return nil, nil, upstream, fmt.Errorf("Need string or int to specify port on upstream Hub \"%s\"", upstream.Name())
+	}
+
+	switch v := dnstreamPort.(type) {
+	case string:
+		if ds, err = dnstream.TrySource(v); err != nil {
+			return nil, nil, dnstream, fmt.Errorf("No source port \"%s\" found on Hub \"%s\"", v, dnstream.Name())
+		}
+	case int:
+		if v < 0 || v >= dnstream.NumSource() {
+			return nil, nil, dnstream, fmt.Errorf("No source port %d found on Hub \"%s\"", v, dnstream.Name())
+		}
+		ds = dnstream.Source(v)
+	default:
+		return nil, nil, dnstream, fmt.Errorf("Need string or int to specify port on downstream Hub \"%s\"", dnstream.Name())
+	}
+	return us, ds, nil, nil
+}
+
+// SetDefaultErrorPolicy sets the error policy for hubs without their own
+func (fg *flowgraph) SetDefaultErrorPolicy(p ErrorPolicy) {
+	if p.Code == ResultOnError {
+		panic(fmt.Sprintf("ResultOnError as the default error policy of flowgraph %q, it needs a result port of each hub", fg.Title()))
+	}
+	fg.policy = &p
+}
+
+// Run runs the flowgraph.  Returns the hub errors joined together.
+func (fg *flowgraph) Run() error {
+	return fg.run()
+}
+
+// RunContext runs the flowgraph until every hub has exited.  When ctx
+// is done every hub is drained with EOS, even one waiting on a source
+// that will never get a value, and RunContext returns once they have
+// exited.  Values already in a While or During loop come out of it
+// first, so a loop that never lets a value out keeps RunContext from
+// returning.  Returns the hub errors joined together, otherwise the
+// context error if ctx ended the run.
+func (fg *flowgraph) RunContext(ctx context.Context) error {
+	return fg.runContext(ctx)
 }
 
 // checkInternalHub checks that the flowgraph associated with a Hub matches
 func checkInternalHub(fg Flowgraph, h Hub) {
+	if err := internalHubError(fg, h); err != nil {
+		panic(err.Error())
+	}
+}
+
+// internalHubError returns an error if the flowgraph associated with a Hub doesn't match
+func internalHubError(fg Flowgraph, h Hub) error {
 	if h == nil {
-		return
+		return nil
 	}
 	fgknown := fg
 	fgtest := h.Flowgraph()
 	if fgknown != fgtest {
-		panic(fmt.Sprintf("Hub %q created by flowgraph %q (expected it to be created by flowgraph %q)",
-			h.Name(), fgtest.Title(), fgknown.Title()))
+		return fmt.Errorf("Hub %q created by flowgraph %q (expected it to be created by flowgraph %q)",
+			h.Name(), fgtest.Title(), fgknown.Title())
 	}
+	return nil
 }
 
 // checkExternalHub checks that the flowgraph associated with a Hub doesn't match
@@ -448,15 +760,12 @@ func checkExternalPipe(fg Flowgraph, s Pipe) {
 }
 
 // flatten connects GraphHub external ports to internal dangling pipes
-func (fg *flowgraph) flatten() []*fgbase.Node {
-	if flatDot {
-		fgbase.DotOutput = true
-	}
+func (fg *flowgraph) flatten(o Options) []*fgbase.Node {
 	nodes := make([]*fgbase.Node, 0)
 	for _, v := range fg.hubs {
-		if gv, ok := v.(GraphHub); ok && (flatDot || !fgbase.DotOutput) {
+		if gv, ok := v.(GraphHub); ok && (o.FlatDot || !o.DotOutput) {
 			nodes = gv.(*graphhub).flatten(nodes)
-			if fgbase.DotOutput {
+			if o.DotOutput {
 				nodes = append(nodes, v.Base().(*fgbase.Node))
 				v.Base().(*fgbase.Node).SetDotAttr("style=\"dashed\"")
 			} else {
@@ -471,21 +780,114 @@ func (fg *flowgraph) flatten() []*fgbase.Node {
 			nodes = append(nodes, v.Base().(*fgbase.Node))
 		}
 	}
-	if fgbase.TraceLevel >= fgbase.V {
-		fmt.Printf("\n")
+	if o.TraceLevel >= fgbase.V {
+		fmt.Fprintf(o.TraceWriter, "\n")
 		for _, v := range nodes {
-			fmt.Printf("// %s\n", v)
+			fmt.Fprintf(o.TraceWriter, "// %s\n", v)
 		}
-		fmt.Printf("\n")
+		fmt.Fprintf(o.TraceWriter, "\n")
 	}
 	return nodes
 }
 
+// writeDot writes the flattened nodes as a dot graph in place of a run,
+// by fgbase if its DotOutput global is set, otherwise to o.TraceWriter
+func (fg *flowgraph) writeDot(o Options, nodes []*fgbase.Node) {
+	if fgbase.DotOutput {
+		fgbase.RunGraph(nodes)
+		return
+	}
+	w := o.TraceWriter
+	fmt.Fprintf(w, "digraph %q {\n", fg.title)
+	for _, n := range nodes {
+		fmt.Fprintf(w, "\t%q;\n", n.Name)
+	}
+	for _, n := range nodes {
+		for _, e := range n.Dsts {
+			if e == nil {
+				continue
+			}
+			for i := 0; i < e.DstCnt(); i++ {
+				if d := e.DstNode(i); d != nil {
+					fmt.Fprintf(w, "\t%q -> %q [label=%q];\n", n.Name, d.Name, e.Name)
+				}
+			}
+		}
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
 // run runs the flowgraph
-func (fg *flowgraph) run() {
+func (fg *flowgraph) run() error {
+	o := fg.runOptions()
+	nodes := fg.flatten(o)
+	if o.DotOutput {
+		fg.writeDot(o, nodes)
+		return nil
+	}
+	nodes, restore := fg.replicate(nodes)
+	defer restore()
+
+	rs := fg.startRun(context.Background(), o, nodes)
+	rs.runGraph(nodes)
+	return rs.result()
+}
+
+// runContext runs the flowgraph and waits for every hub to exit, then for
+// the executor to return
+func (fg *flowgraph) runContext(ctx context.Context) error {
+	o := fg.runOptions()
+	nodes := fg.flatten(o)
+	if o.DotOutput {
+		fg.writeDot(o, nodes)
+		return nil
+	}
+	nodes, restore := fg.replicate(nodes)
+	defer restore()
+
+	rs := fg.startRun(ctx, o, nodes)
+	rs.executed = make(chan struct{})
+	go func() {
+		defer close(rs.executed)
+		rs.execute(nodes, true)
+	}()
+	return rs.wait()
+}
+
+// startRun readies the flattened nodes of the flowgraph for a run
+func (fg *flowgraph) startRun(ctx context.Context, o Options, nodes []*fgbase.Node) *runState {
+	rs := newRunState(ctx, fg.policy, o)
+	rs.looped = loopedNodes(nodes)
+	rs.initStats(nodes)
+	for _, n := range nodes {
+		if h, ok := n.Owner.(Hub); ok && h.HubCode() == Wait {
+			waitInit(n)
+		}
+		rs.wrap(n)
+	}
+	rs.watchExits()
+	if o.StallTimeout > 0 {
+		rs.watch(fg.title, nodes, fg.hubNames(), o.StallTimeout, o.FailOnStall)
+	}
+	if o.TraceEvents != nil {
+		rs.events = fg.newEventRecorder(nodes, o.TraceEvents)
+	}
+	if o.VCD != nil {
+		rs.vcd, rs.vcdTo = fg.newVCDRecorder(nodes, o.VCDFirings), o.VCD
+	}
+	fg.setRunState(rs)
+	return rs
+}
 
-	nodes := fg.flatten()
-	fgbase.RunGraph(nodes)
+// setRunState shares the state of a run with this and every nested
+// flowgraph, once it is ready to be read by Stats
+func (fg *flowgraph) setRunState(rs *runState) {
+	fg.rs.Store(rs)
+	for _, h := range fg.hubs {
+		if gh, ok := h.(*graphhub); ok {
+			gh.fg.(*flowgraph).setRunState(rs)
+		}
+	}
 }
 
 func allOfFire(n *fgbase.Node) error {
@@ -501,7 +903,13 @@ func allOfFire(n *fgbase.Node) error {
 			eofflag = true
 		}
 	}
-	x, _ := t.Transform(&hub{n, fg, AllOf}, a)
+	var x []interface{}
+	if err := fg.callUser(n, func() (err error) {
+		x, err = t.Transform(&hub{n, fg, AllOf}, a)
+		return err
+	}); err != nil && !isEOS(err) {
+		return nil
+	}
 	for i, _ := range x {
 		if eofflag {
 			n.Dsts[i].DstPut(EOS)
@@ -554,7 +962,13 @@ func oneOfFire(n *fgbase.Node) error {
 			break
 		}
 	}
-	x, _ := t.Transform(&hub{n, fg, OneOf}, a)
+	var x []interface{}
+	if err := fg.callUser(n, func() (err error) {
+		x, err = t.Transform(&hub{n, fg, OneOf}, a)
+		return err
+	}); err != nil && !isEOS(err) {
+		return nil
+	}
 	for i, _ := range x {
 		if eofflag {
 			n.Dsts[i].DstPut(EOS)
@@ -580,7 +994,14 @@ func retrieveRdy(n *fgbase.Node) bool {
 func retrieveFire(n *fgbase.Node) error {
 	retriever := n.Aux.(*fgRetriever).r
 	fg := n.Aux.(*fgRetriever).fg
-	v, err := retriever.Retrieve(&hub{n, fg, Retrieve})
+	var v interface{}
+	err := fg.callUser(n, func() (err error) {
+		v, err = retriever.Retrieve(&hub{n, fg, Retrieve})
+		return err
+	})
+	if err != nil && !isEOS(err) {
+		return nil
+	}
 	n.Dsts[0].DstPut(v)
 	return err
 }
@@ -588,13 +1009,46 @@ func retrieveFire(n *fgbase.Node) error {
 func transmitFire(n *fgbase.Node) error {
 	transmitter := n.Aux.(*fgTransmitter).t
 	fg := n.Aux.(*fgTransmitter).fg
-	err := transmitter.Transmit(&hub{n, fg, Transmit}, n.Srcs[0].SrcGet())
+	v := n.Srcs[0].SrcGet()
+	err := fg.callUser(n, func() error {
+		return transmitter.Transmit(&hub{n, fg, Transmit}, v)
+	})
+	if err != nil && !isEOS(err) {
+		return nil
+	}
 	return err
 }
 
 type waitStruct struct {
 	Request  int
 	Transmit *fgTransmitter
+	admitted int  // values let into the loop
+	consumed int  // termination values taken back, the initial one too
+	eos      bool // an EOS waits for the loop to empty
+	last     bool // the loop is empty, so pass the EOS on
+}
+
+// waitInit readies a Wait node to let depth-1 values into its loop before
+// the first termination value comes back, with room for them on the
+// termination pipe.  Called before the run starts, since the room is made
+// on the copy of the pipe of the node upstream.
+func waitInit(n *fgbase.Node) {
+	if _, init := n.Aux.(waitStruct); init {
+		return
+	}
+	tr, _ := n.Aux.(*fgTransmitter)
+	elocal := n.Srcs[n.SrcCnt()-1]
+	depth := n.Owner.(Hub).Flowgraph().(*flowgraph).capacity(elocal)
+	n.Aux = waitStruct{Request: depth - 1, Transmit: tr}
+	usnode := elocal.SrcNode(0)
+	if usnode != nil {
+		for i := 0; i < len(usnode.Dsts); i++ {
+			if usnode.Dsts[i].Same(elocal) {
+				usnode.Dsts[i].RdyCnt += depth - 1
+				break
+			}
+		}
+	}
 }
 
 func waitRdy(n *fgbase.Node) bool {
@@ -608,19 +1062,27 @@ func waitRdy(n *fgbase.Node) bool {
 
 	ws, init := n.Aux.(waitStruct)
 	if !init {
-		tr, _ := n.Aux.(*fgTransmitter)
-		ws = waitStruct{Request: fgbase.ChannelSize - 1, Transmit: tr}
+		waitInit(n)
+		ws = n.Aux.(waitStruct)
+	}
+
+	for i := 0; i < ns-1 && !ws.eos; i++ {
+		ws.eos = n.Srcs[i].SrcRdy(n) && isEOS(n.Srcs[i].Val)
+	}
+	if ws.eos {
+		// take back a termination value for every one let in before the EOS
+		for i := 0; i < ns-1; i++ {
+			n.Srcs[i].Flow = false
+		}
+		ws.last = ws.consumed > ws.admitted
 		n.Aux = ws
-		elocal := n.Srcs[ns-1]
-		usnode := elocal.SrcNode(0)
-		if usnode != nil {
-			for i := 0; i < len(usnode.Dsts); i++ {
-				if usnode.Dsts[i].Same(elocal) {
-					usnode.Dsts[i].RdyCnt += fgbase.ChannelSize - 1
-					break
-				}
-			}
+		if ws.last {
+			n.Srcs[ns-1].Flow = false
+			return true
 		}
+		rdy := n.Srcs[ns-1].SrcRdy(n)
+		n.Srcs[ns-1].Flow = rdy
+		return rdy
 	}
 
 	for i := 0; i < ns-1; i++ {
@@ -644,24 +1106,130 @@ func waitRdy(n *fgbase.Node) bool {
 
 func waitFire(n *fgbase.Node) error {
 	ws := n.Aux.(waitStruct)
+	ns := n.SrcCnt()
+	if ws.eos {
+		if !ws.last {
+			ws.consumed++
+			n.Aux = ws
+			return nil
+		}
+		for i := 0; i < ns-1; i++ {
+			n.Srcs[i].Flow = n.Srcs[i].Val != nil
+		}
+		for _, e := range n.Dsts {
+			e.DstPut(EOS)
+		}
+		return EOS
+	}
+
 	if ws.Transmit != nil {
 		transmitter := ws.Transmit.t
 		fg := ws.Transmit.fg
-		err := transmitter.Transmit(&hub{n, fg, Transmit}, n.Srcs[0].SrcGet())
-		if err != nil {
-			n.LogError("Error in waitFire use of transmitter:  %s\n", err)
-		}
+		v := n.Srcs[0].SrcGet()
+		fg.callUser(n, func() error { // the token passes regardless, Transmit only taps it
+			return transmitter.Transmit(&hub{n, fg, Transmit}, v)
+		})
 	}
 
-	ns := n.SrcCnt()
 	for i := 0; i < ns-1; i++ {
 		n.Dsts[i].DstPut(n.Srcs[i].SrcGet())
 	}
+	ws.admitted++
+	if n.Srcs[ns-1].Flow {
+		ws.consumed++
+	}
+	n.Aux = ws
 	return nil
 }
 
+// selectIndex converts the value on the first source of a Select hub
+// into the index of the data source to consume.  Integers select directly,
+// bool and Breaker select 0 for false/break and 1 otherwise.
+func selectIndex(v interface{}) (int, bool) {
+	switch x := v.(type) {
+	case int:
+		return x, true
+	case int8:
+		return int(x), true
+	case int16:
+		return int(x), true
+	case int32:
+		return int(x), true
+	case int64:
+		return int(x), true
+	case uint:
+		return int(x), true
+	case uint8:
+		return int(x), true
+	case uint16:
+		return int(x), true
+	case uint32:
+		return int(x), true
+	case uint64:
+		return int(x), true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	case Breaker:
+		if x.Break() {
+			return 0, true
+		}
+		return 1, true
+	}
+	return -1, false
+}
+
+type selectStruct struct {
+	sel int
+	eos bool
+}
+
+func selectRdy(n *fgbase.Node) bool {
+	if !n.Srcs[0].SrcRdy(n) || !n.Dsts[0].DstRdy(n) {
+		return false
+	}
+
+	ss := selectStruct{sel: -1}
+	v := n.Srcs[0].SrcGet()
+	if err, ok := v.(error); ok && errors.Is(err, fgbase.EOS) {
+		ss.eos = true
+	} else if i, ok := selectIndex(v); ok && i >= 0 && i < n.SrcCnt()-1 {
+		ss.sel = i
+	}
+	n.Aux = ss
+
+	// only the selected source is drained, the rest keep their backpressure
+	for i := 1; i < n.SrcCnt(); i++ {
+		if i != ss.sel+1 {
+			n.Srcs[i].Flow = false
+		}
+	}
+	if ss.sel < 0 {
+		return true
+	}
+	return n.Srcs[ss.sel+1].SrcRdy(n)
+}
+
 func selectFire(n *fgbase.Node) error {
-	n.Panicf("Select HubCode still needs implementation.")
+	ss := n.Aux.(selectStruct)
+	v := n.Srcs[0].SrcGet()
+
+	if ss.eos {
+		n.Dsts[0].DstPut(EOS)
+		return EOS
+	}
+	if ss.sel < 0 {
+		n.Owner.(Hub).Flowgraph().(*flowgraph).hubError(n, fmt.Errorf("Select value %T(%+v) out of range for %d sources", v, v, n.SrcCnt()-1))
+		return nil
+	}
+
+	x := n.Srcs[ss.sel+1].SrcGet()
+	n.Dsts[0].DstPut(x)
+	if err, ok := x.(error); ok && errors.Is(err, fgbase.EOS) {
+		return EOS
+	}
 	return nil
 }
 
@@ -766,13 +1334,61 @@ func crossFire(n *fgbase.Node) error {
 	return nil
 }
 
+// splitFire sends element i of a []interface{} to result i.  A slice
+// shorter than the number of results is padded with the init value given
+// to NewHub, or dropped with an error if there is none.  A longer slice
+// is always dropped with an error.  Errors go to the error policy of the
+// hub.
 func splitFire(n *fgbase.Node) error {
-	n.Panicf("Split HubCode still needs implementation.")
+	v := n.Srcs[0].SrcGet()
+	nr := n.DstCnt()
+
+	if err, ok := v.(error); ok && errors.Is(err, fgbase.EOS) {
+		for i := 0; i < nr; i++ {
+			n.Dsts[i].DstPut(EOS)
+		}
+		return EOS
+	}
+
+	fg := n.Owner.(Hub).Flowgraph().(*flowgraph)
+	a, ok := v.([]interface{})
+	if !ok {
+		fg.hubError(n, fmt.Errorf("Split given %T(%+v) instead of []interface{}", v, v))
+		return nil
+	}
+	pad := n.Aux
+	if len(a) > nr || (len(a) < nr && pad == nil) {
+		fg.hubError(n, fmt.Errorf("Split given slice of length %d for %d results", len(a), nr))
+		return nil
+	}
+
+	for i := 0; i < nr; i++ {
+		if i < len(a) {
+			n.Dsts[i].DstPut(a[i])
+		} else {
+			n.Dsts[i].DstPut(pad)
+		}
+	}
 	return nil
 }
 
+// joinFire gathers one value from every source into a []interface{}.
+// EOS on any source is passed on as EOS.
 func joinFire(n *fgbase.Node) error {
-	n.Panicf("Join HubCode still needs implementation.")
+	a := make([]interface{}, n.SrcCnt())
+	eofflag := false
+	for i := range a {
+		a[i] = n.Srcs[i].SrcGet()
+		if v, ok := a[i].(error); ok && errors.Is(v, fgbase.EOS) {
+			n.Srcs[i].Flow = false
+			eofflag = true
+		}
+	}
+	if eofflag {
+		n.Dsts[0].DstPut(EOS)
+		return EOS
+	}
+	n.Dsts[0].DstPut(a)
 	return nil
 }
 
@@ -791,26 +1407,6 @@ func graphFire(n *fgbase.Node) error {
 	return nil
 }
 
-func andFire(n *fgbase.Node) error {
-	n.Panicf("And HubCode still needs implementation.")
-	return nil
-}
-
-func orFire(n *fgbase.Node) error {
-	n.Panicf("Or HubCode still needs implementation.")
-	return nil
-}
-
-func notFire(n *fgbase.Node) error {
-	n.Panicf("Not HubCode still needs implementation.")
-	return nil
-}
-
-func shiftFire(n *fgbase.Node) error {
-	n.Panicf("Shift HubCode still needs implementation.")
-	return nil
-}
-
 func min(a, b int) int {
 	if a < b {
 		return a
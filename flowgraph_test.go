@@ -4,10 +4,15 @@ import (
 	"github.com/vectaport/fgbase"
 	"github.com/vectaport/flowgraph"
 
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"math/rand"
 	"os"
+	"runtime"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -1085,255 +1090,3092 @@ func TestIterator9(t *testing.T) {
 
 /*=====================================================================*/
 
-/* TestGCD Flowgraph HDL *
+/* TestWhileInvariant Flowgraph HDL *
 
-rand100()(mval)
-rand100()(nval)
-while(mval, nval)(tcond, gcd) {
-        pass(mval)(gcd)
-        mod(nval, mval)(tcond)
+twelve()(firstval)
+three()(stepval)
+while(firstval, stepval)(lastval) {
+        sub(firstval, stepval)(lastval)
 }
-sink(gcd)()
-sink2(tcond)()
+sink(lastval)()
 
 */
 
-type rand100 struct {
-	init bool
+type sinkWhileInvariant struct {
+	t   *testing.T
+	cnt int
 }
 
-func (t *rand100) Retrieve(n flowgraph.Hub) (result interface{}, err error) {
-	if !(*t).init {
-		// (*t).init = true
-		return rand.Intn(100) + 1, nil
+func (st *sinkWhileInvariant) Sink(source []interface{}) {
+	if source[0].(int) != 0 {
+		st.t.Fatalf("ERROR WhileInvariant FAILED:  %v\n", source[0])
 	}
-	return flowgraph.EOS, flowgraph.EOS
+	st.cnt++
 }
 
-func TestGCD(t *testing.T) {
-	fmt.Printf("BEGIN:  TestGCD\n")
+func TestWhileInvariant(t *testing.T) {
+	fmt.Printf("BEGIN:  TestWhileInvariant\n")
 	oldRunTime := fgbase.RunTime
 	oldTraceLevel := fgbase.TraceLevel
-	fgbase.RunTime = time.Second * 1
+	fgbase.RunTime = time.Second
 	fgbase.TraceLevel = fgbase.V
 
-	fg := flowgraph.New("TestGCD")
+	fg := flowgraph.New("TestWhileInvariant")
 
-	mval := fg.NewPipe("mval")
-	nval := fg.NewPipe("nval")
-	tcond := fg.NewPipe("tcond")
-	gcd := fg.NewPipe("gcd")
+	firstval := fg.NewPipe("firstval")
+	stepval := fg.NewPipe("stepval")
+	lastval := fg.NewPipe("lastval")
 
-	fg.NewHub("rand100", flowgraph.Retrieve, &rand100{}).
-		ConnectResults(mval)
-	fg.NewHub("rand100", flowgraph.Retrieve, &rand100{}).
-		ConnectResults(nval)
+	fg.NewHub("twelve", flowgraph.Constant, 12).
+		ConnectResults(firstval)
+	fg.NewHub("three", flowgraph.Constant, 3).
+		ConnectResults(stepval)
 
 	while := fg.NewGraphHub("while", flowgraph.While)
-	while.ConnectSources(mval, nval).
-		ConnectResults(tcond, gcd)
-
-	passm := while.NewHub("passm", flowgraph.Pass, nil)
-	mod := while.NewHub("mod", flowgraph.Modulo, nil)
-	while.ExposeResult(while.Connect(passm, 0, mod, 1))
+	while.ConnectSources(firstval, stepval).
+		ConnectResults(lastval)
 
+	while.NewHub("sub", flowgraph.Subtract, nil) // stepval is not recirculated
 	while.Loop()
 
-	fg.NewHub("sink", flowgraph.Sink, nil).
-		ConnectSources(gcd)
-	fg.NewHub("sink2", flowgraph.Sink, nil).
-		ConnectSources(tcond)
+	s := &sinkWhileInvariant{t: t}
+	fg.NewHub("sink", flowgraph.Sink, s).
+		ConnectSources(lastval)
 
 	fg.Run()
 
+	if s.cnt == 0 {
+		t.Fatalf("ERROR WhileInvariant FAILED:  no results\n")
+	}
+
 	fgbase.RunTime = oldRunTime
 	fgbase.TraceLevel = oldTraceLevel
-	fmt.Printf("END:    TestGCD\n")
-	// fmt.Printf("RESULTS %d,%d\n", fgbase.ChannelSize, while.Hub(3).Base().(*fgbase.Node).Cnt)
+	fmt.Printf("END:    TestWhileInvariant\n")
 }
 
 /*=====================================================================*/
 
-/* TestGoRound Flowgraph HDL *
+/* TestLoopMismatch Flowgraph HDL *
 
-ival,jval,kval,lval,mval,nval=tbcar(),tbcar(),tbcar(),tbcar(),tbcar(),tbcar()
-while(ival,jval,kval,lval,mval,nval)(iout,jout,kout,lout,mout,nout) {
-        iout,jout,kout,lout,mout,nout=onelap(ival,jval,kval,lval,mval,nval)
+first()(firstval)
+while(firstval)(lastval, extraval) {
+        sub(firstval, 1)(subval)
+        add(subval, 100)(addval)
 }
-sink(iout)
-sink(jout)
-sink(kout)
-sink(lout)
-sink(mout)
-sink(nout)
 
 */
 
-type car struct {
-	number   int64
-	distance int
-	winner   int
+func TestLoopMismatch(t *testing.T) {
+	fmt.Printf("BEGIN:  TestLoopMismatch\n")
+
+	tests := []struct {
+		name    string
+		results int
+		extra   bool
+		want    string
+	}{
+		{"no source for extra result", 2, true, "add[0] has no source"},
+		{"Loop before ConnectResults", 0, false, "sub[0] has no GraphHub result port"},
+	}
+
+	for _, test := range tests {
+
+		fg := flowgraph.New("TestLoopMismatch")
+
+		firstval := fg.NewPipe("firstval")
+		fg.NewHub("first", flowgraph.Constant, 10).
+			ConnectResults(firstval)
+
+		while := fg.NewGraphHub("while", flowgraph.While)
+		while.ConnectSources(firstval)
+		if test.results > 0 {
+			while.ConnectResults(fg.NewPipe("lastval"), fg.NewPipe("extraval"))
+		}
+
+		subval := while.NewPipe("subval")
+		while.NewHub("sub", flowgraph.Subtract, nil).
+			ConnectSources(nil, while.NewPipe("oneval").Const(1)).
+			ConnectResults(subval)
+		while.ExposeResult(subval)
+		if test.extra {
+			addval := while.NewPipe("addval")
+			while.NewHub("add", flowgraph.Add, nil).
+				ConnectSources(subval, while.NewPipe("hundredval").Const(100)).
+				ConnectResults(addval)
+			while.ExposeResult(addval)
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), test.want) {
+					t.Fatalf("ERROR LoopMismatch %s panicked with %v, expected %q\n", test.name, r, test.want)
+				}
+			}()
+			while.Loop()
+		}()
+	}
+
+	fmt.Printf("END:    TestLoopMismatch\n")
 }
 
-func (c *car) Break() bool {
-	return c.winner != -1
+/*=====================================================================*/
+
+/* TestDuring1 Flowgraph HDL *
+
+ten()(firstval)
+during(firstval)(everyval) {
+        sub(firstval, 1)(everyval)
 }
+sink(everyval)()
 
-type tbcar struct {
+*/
+
+type sinkDuring struct {
+	t    *testing.T
+	name string
+	i    int
+	c    int
 }
 
-func (t *tbcar) Retrieve(h flowgraph.Hub) (result interface{}, err error) {
-	return &car{h.Base().(*fgbase.Node).ID, rand.Intn(100) + 1, -1}, nil
+func (st *sinkDuring) Sink(source []interface{}) {
+	st.i--
+	if st.i != source[0].(int) {
+		st.t.Fatalf("ERROR %s result is %d, expected %d\n", st.name, source[0].(int), st.i)
+	}
+	if st.i == 0 {
+		st.i = 10
+	}
+	st.c++
 }
 
-type onelap struct{}
+func TestDuring1(t *testing.T) {
+	fmt.Printf("BEGIN:  TestDuring1\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TraceLevel = fgbase.V
 
-func (l *onelap) Transform(n flowgraph.Hub, source []interface{}) (result []interface{}, err error) {
-	result = make([]interface{}, len(source))
-	for i := range source {
-		cprev := source[i].(*car)
-		cnext := &car{cprev.number, max(0, cprev.distance-rand.Intn(6)+1), -1}
-		result[i] = cnext
-		if cnext.distance == 0 {
-			czero := result[0].(*car)
-			czero.winner = i
-		}
+	fg := flowgraph.New("TestDuring1")
+
+	firstval := fg.NewPipe("firstval")
+	everyval := fg.NewPipe("everyval")
+
+	fg.NewHub("ten", flowgraph.Retrieve, &ten{}).
+		ConnectResults(firstval)
+
+	during := fg.NewGraphHub("during", flowgraph.During)
+	during.ConnectSources(firstval).
+		ConnectResults(everyval)
+
+	oneval := during.NewPipe("oneval").Const(1)
+	during.NewHub("sub", flowgraph.Subtract, nil).
+		ConnectSources(nil, oneval)
+	during.Loop()
+
+	st := &sinkDuring{t, "During1", 10, 0}
+	fg.NewHub("sink", flowgraph.Sink, st).
+		ConnectSources(everyval)
+
+	fg.Run()
+
+	fmt.Printf("// SINKS: %d\n", st.c)
+	if st.c < 10 {
+		t.Fatalf("ERROR During1 sank %d values, expected every iteration of at least one loop\n", st.c)
 	}
-	return
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestDuring1\n")
 }
 
-func TestGoRound(t *testing.T) {
-	fmt.Printf("BEGIN:  TestGoRound\n")
+/*=====================================================================*/
+
+/* TestDuring2 Flowgraph HDL *
+
+ten()(firstval1)
+during1(firstval1)(everyval1) {
+        sub1(firstval1, 1)(everyval1)
+}
+wait(10,everyval1)(firstval2)
+during2(firstval2)(everyval2) {
+        sub2(firstval2, 1)(everyval2)
+}
+sink(everyval2)()
+
+*/
+
+func TestDuring2(t *testing.T) {
+	fmt.Printf("BEGIN:  TestDuring2\n")
 	oldRunTime := fgbase.RunTime
 	oldTraceLevel := fgbase.TraceLevel
 	fgbase.RunTime = time.Second
 	fgbase.TraceLevel = fgbase.V
 
-	fg := flowgraph.New("TestGoRound")
+	fg := flowgraph.New("TestDuring2")
 
-	ival := fg.NewPipe("ival")
-	jval := fg.NewPipe("jval")
-	kval := fg.NewPipe("kval")
-	lval := fg.NewPipe("lval")
-	mval := fg.NewPipe("mval")
-	nval := fg.NewPipe("nval")
+	firstval1 := fg.NewPipe("firstval1")
+	fg.NewHub("ten", flowgraph.Retrieve, &ten{}).
+		ConnectResults(firstval1)
 
-	iout := fg.NewPipe("iout")
-	jout := fg.NewPipe("jout")
-	kout := fg.NewPipe("kout")
-	lout := fg.NewPipe("lout")
-	mout := fg.NewPipe("mout")
-	nout := fg.NewPipe("nout")
+	everyval1 := fg.NewPipe("everyval1")
+	during1 := fg.NewGraphHub("during1", flowgraph.During)
+	during1.ConnectSources(firstval1).
+		ConnectResults(everyval1)
 
-	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
-		ConnectResults(ival)
-	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
-		ConnectResults(jval)
-	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
-		ConnectResults(kval)
-	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
-		ConnectResults(lval)
-	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
-		ConnectResults(mval)
-	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
-		ConnectResults(nval)
+	oneval1 := during1.NewPipe("oneval1").Const(1)
+	during1.NewHub("sub1", flowgraph.Subtract, nil).
+		ConnectSources(nil, oneval1)
+	during1.Loop()
 
-	while := fg.NewGraphHub("while", flowgraph.While)
-	while.ConnectSources(ival, jval, kval, lval, mval, nval).
-		ConnectResults(iout, jout, kout, lout, mout, nout)
+	tenval := fg.NewPipe("tenval").Const(10)
+	firstval2 := fg.NewPipe("firstval2")
+	fg.NewHub("wait", flowgraph.Wait, 1).
+		ConnectSources(tenval, everyval1).
+		ConnectResults(firstval2)
 
-	while.NewHub("onelap", flowgraph.AllOf, &onelap{}).
-		SetNumSource(6).
-		SetNumResult(6)
+	everyval2 := fg.NewPipe("everyval2")
+	during2 := fg.NewGraphHub("during2", flowgraph.During)
+	during2.ConnectSources(firstval2).
+		ConnectResults(everyval2)
 
-	while.Loop()
+	oneval2 := during2.NewPipe("oneval2").Const(1)
+	during2.NewHub("sub2", flowgraph.Subtract, nil).
+		ConnectSources(nil, oneval2)
+	during2.Loop()
 
-	fg.NewHub("sinki", flowgraph.Sink, nil).
-		ConnectSources(iout)
-	fg.NewHub("sinkj", flowgraph.Sink, nil).
-		ConnectSources(jout)
-	fg.NewHub("sinkk", flowgraph.Sink, nil).
-		ConnectSources(kout)
-	fg.NewHub("sinkl", flowgraph.Sink, nil).
-		ConnectSources(lout)
-	fg.NewHub("sinkm", flowgraph.Sink, nil).
-		ConnectSources(mout)
-	fg.NewHub("sinkn", flowgraph.Sink, nil).
-		ConnectSources(nout)
+	st := &sinkDuring{t, "During2", 10, 0}
+	fg.NewHub("sink", flowgraph.Sink, st).
+		ConnectSources(everyval2)
 
 	fg.Run()
 
+	fmt.Printf("// SINKS: %d\n", st.c)
+	if st.c < 10 {
+		t.Fatalf("ERROR During2 sank %d values, expected every iteration of at least one loop\n", st.c)
+	}
 	fgbase.RunTime = oldRunTime
 	fgbase.TraceLevel = oldTraceLevel
-	fmt.Printf("END:    TestGoRound\n")
+	fmt.Printf("END:    TestDuring2\n")
 }
 
 /*=====================================================================*/
 
-/* TestCross Flowgraph HDL *
+/* TestDuring3 Flowgraph HDL *
 
-ival,jval=tbtoggle(),rand100()
-cross(ival,jval)(iout,jout)
-sink(iout)
-sink(kout)
+ten()(firstval)
+during(firstval)(everyval) {
+        add(firstval,1)(bumpval)
+        while(bumpval)(everyval) {
+                sub(bumpval, 1)(everyval)
+        }
+}
+sink(everyval)()
 
 */
 
-type tbtoggle struct {
-	last bool
+// sinkDuringZero expects every value to be 0, as from a During loop whose
+// body is a While loop, run once for each value
+type sinkDuringZero struct {
+	t    *testing.T
+	name string
+	c    int
 }
 
-func (t *tbtoggle) Retrieve(n flowgraph.Hub) (result interface{}, err error) {
-	f := t.last
-	t.last = !f
-	return f, nil
+func (st *sinkDuringZero) Sink(source []interface{}) {
+	if source[0].(int) != 0 {
+		st.t.Fatalf("ERROR %s result is %v, expected 0\n", st.name, source[0])
+	}
+	st.c++
 }
 
-func TestCross(t *testing.T) {
-	fmt.Printf("BEGIN:  TestCross\n")
+func TestDuring3(t *testing.T) {
+	fmt.Printf("BEGIN:  TestDuring3\n")
 	oldRunTime := fgbase.RunTime
 	oldTraceLevel := fgbase.TraceLevel
 	fgbase.RunTime = time.Second
 	fgbase.TraceLevel = fgbase.V
 
-	fg := flowgraph.New("TestCross")
+	fg := flowgraph.New("TestDuring3")
 
-	ival := fg.NewPipe("ival")
-	jval := fg.NewPipe("jval")
-	kval := fg.NewPipe("kval")
-	lval := fg.NewPipe("lval")
-	iout := fg.NewPipe("iout")
-	jout := fg.NewPipe("jout")
-	kout := fg.NewPipe("kout")
-	lout := fg.NewPipe("lout")
+	firstval := fg.NewPipe("firstval")
+	fg.NewHub("ten", flowgraph.Retrieve, &ten{}).
+		ConnectResults(firstval)
 
-	fg.NewHub("tbtoggle", flowgraph.Retrieve, &tbtoggle{}).
-		ConnectResults(ival)
-	fg.NewHub("rand100", flowgraph.Retrieve, &rand100{}).
-		ConnectResults(jval)
-	fg.NewHub("tbtoggle", flowgraph.Retrieve, &tbtoggle{}).
-		ConnectResults(kval)
-	fg.NewHub("rand100", flowgraph.Retrieve, &rand100{}).
-		ConnectResults(lval)
+	everyval := fg.NewPipe("everyval")
+	during := fg.NewGraphHub("during", flowgraph.During)
+	during.ConnectSources(firstval).
+		ConnectResults(everyval)
 
-	fg.NewHub("cross", flowgraph.Cross, nil).
-		ConnectSources(ival, jval, kval, lval).
-		ConnectResults(iout, jout, kout, lout)
+	while := during.NewGraphHub("while", flowgraph.While)
+	while.SetNumSource(1).SetNumResult(1)
+	oneval := during.NewPipe("oneval").Const(1)
+	add := during.NewHub("add", flowgraph.Add, nil).
+		ConnectSources(nil, oneval)
+	during.Connect(add, 0, while, 0).SetName("bumpval")
+	during.Loop()
 
-	fg.NewHub("sinki", flowgraph.Sink, nil).
-		ConnectSources(iout)
-	fg.NewHub("sinkj", flowgraph.Sink, nil).
-		ConnectSources(jout)
-	fg.NewHub("sinkk", flowgraph.Sink, nil).
-		ConnectSources(kout)
-	fg.NewHub("sinkl", flowgraph.Sink, nil).
-		ConnectSources(lout)
+	oneval2 := while.NewPipe("oneval2").Const(1)
+	while.NewHub("sub", flowgraph.Subtract, nil).
+		ConnectSources(nil, oneval2)
+	while.Loop()
+
+	st := &sinkDuringZero{t, "During3", 0}
+	fg.NewHub("sink", flowgraph.Sink, st).
+		ConnectSources(everyval)
 
 	fg.Run()
 
+	if st.c == 0 {
+		t.Fatalf("ERROR During3 sank nothing\n")
+	}
+
 	fgbase.RunTime = oldRunTime
 	fgbase.TraceLevel = oldTraceLevel
-	fmt.Printf("END:    TestCross\n")
+	fmt.Printf("END:    TestDuring3\n")
+}
+
+/*=====================================================================*/
+
+/* TestDuring4 Flowgraph HDL *
+
+tbcounts()(firstval)
+during(firstval)(everyval) {
+        sub(firstval, 1)(everyval)
+}
+sink(everyval)()
+
+*/
+
+type valDuring struct {
+	Count int
+	ID    int
+}
+
+func (vd *valDuring) Break() bool {
+	return vd.Count == 0
+}
+
+func (vd *valDuring) Clear() {}
+
+type tbcounts struct {
+	id int
+}
+
+func (t *tbcounts) Retrieve(n flowgraph.Hub) (result interface{}, err error) {
+	t.id++
+	return &valDuring{10, t.id}, nil
+}
+
+type subDuring struct{}
+
+func (s *subDuring) Transform(n flowgraph.Hub, source []interface{}) (result []interface{}, err error) {
+	v := source[0].(*valDuring)
+	return []interface{}{&valDuring{v.Count - source[1].(int), v.ID}}, nil
+}
+
+type sinkDuring4 struct {
+	t     *testing.T
+	count map[int]int
+}
+
+func (st *sinkDuring4) Sink(source []interface{}) {
+	v := source[0].(*valDuring)
+	next, ok := st.count[v.ID]
+	if !ok {
+		next = 9
+	}
+	if v.Count != next {
+		st.t.Fatalf("ERROR During4 ID %d Count is %d, expected %d\n", v.ID, v.Count, next)
+	}
+	st.count[v.ID] = next - 1
+}
+
+func TestDuring4(t *testing.T) {
+	fmt.Printf("BEGIN:  TestDuring4\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestDuring4")
+
+	firstval := fg.NewPipe("firstval")
+	everyval := fg.NewPipe("everyval")
+
+	fg.NewHub("tbcounts", flowgraph.Retrieve, &tbcounts{}).
+		ConnectResults(firstval)
+
+	during := fg.NewGraphHub("during", flowgraph.During)
+	during.ConnectSources(firstval).
+		ConnectResults(everyval)
+
+	oneval := during.NewPipe("oneval").Const(1)
+	during.NewHub("sub", flowgraph.AllOf, &subDuring{}).
+		ConnectSources(nil, oneval).
+		SetNumResult(1)
+	during.Loop()
+
+	st := &sinkDuring4{t, make(map[int]int)}
+	fg.NewHub("sink", flowgraph.Sink, st).
+		ConnectSources(everyval)
+
+	fg.Run()
+
+	done := 0
+	for _, next := range st.count {
+		if next < 0 {
+			done++
+		}
+	}
+	if done == 0 {
+		t.Fatalf("ERROR During4 finished no loops\n")
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestDuring4\n")
+}
+
+/*=====================================================================*/
+
+/* TestDuring5 Flowgraph HDL *
+
+ten()(.X(firstval))
+during(.A(firstval))(.X(everyval)) {
+        sub(.A(firstval),.B(1))(.X(everyval))
+}
+sink(.A(everyval))()
+
+*/
+
+// TestDuring5 is TestDuring1 with hubs connected by port name, as in
+// TestIterator1
+func TestDuring5(t *testing.T) {
+	fmt.Printf("BEGIN:  TestDuring5\n")
+	oldRunTime := fgbase.RunTime
+	oldTracePorts := fgbase.TracePorts
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TracePorts = true
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestDuring5")
+
+	ten := fg.NewHub("ten", flowgraph.Constant, 10).
+		SetResultNames("X")
+
+	during := fg.NewGraphHub("during", flowgraph.During)
+	during.SetSourceNames("A").
+		SetResultNames("X")
+
+	one := during.NewHub("one", flowgraph.Constant, 1).
+		SetResultNames("X")
+	sub := during.NewHub("sub", flowgraph.Subtract, nil).
+		SetSourceNames("A", "B").
+		SetResultNames("X")
+	during.Connect(one, "X", sub, "B").SetName("oneval")
+	during.Loop()
+
+	st := &sinkDuring{t, "During5", 10, 0}
+	sink := fg.NewHub("sink", flowgraph.Sink, st).
+		SetSourceNames("A")
+
+	fg.Connect(ten, "X", during, "A").SetName("firstval")
+	fg.Connect(during, "X", sink, "A").SetName("everyval")
+
+	fg.Run()
+
+	fmt.Printf("// SINKS: %d\n", st.c)
+	if st.c < 10 {
+		t.Fatalf("ERROR During5 sank %d values, expected every iteration of at least one loop\n", st.c)
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TracePorts = oldTracePorts
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestDuring5\n")
+}
+
+/*=====================================================================*/
+
+/* TestDuring6 Flowgraph HDL *
+
+ten()(firstval)
+during(firstval)(everyval) {
+        while1(firstval)(everyval) {
+                ...
+                        whilen(firstval)(everyval) {
+                                sub(firstval, 1)(everyval)
+                        }
+                ...
+        }
+}
+sink(everyval)()
+
+*/
+
+// TestDuring6 nests While loops one to three deep in a During loop, as
+// TestIterator5 through TestIterator7 nest them in a While loop.  The
+// innermost loop counts down to 0, so the During loop runs once for each
+// value and puts out 0.
+func TestDuring6(t *testing.T) {
+	fmt.Printf("BEGIN:  TestDuring6\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second / 2
+	fgbase.TraceLevel = fgbase.V
+
+	for depth := 1; depth <= 3; depth++ {
+
+		fg := flowgraph.New("TestDuring6")
+
+		firstval := fg.NewPipe("firstval")
+		fg.NewHub("ten", flowgraph.Retrieve, &ten{}).
+			ConnectResults(firstval)
+
+		everyval := fg.NewPipe("everyval")
+		during := fg.NewGraphHub("during", flowgraph.During)
+		during.ConnectSources(firstval).
+			ConnectResults(everyval)
+
+		outer := during
+		for i := 1; i <= depth; i++ {
+			while := outer.NewGraphHub(fmt.Sprintf("while%d", i), flowgraph.While)
+			while.SetNumSource(1).SetNumResult(1)
+			outer.Loop()
+			outer = while
+		}
+		oneval := outer.NewPipe("oneval").Const(1)
+		outer.NewHub("sub", flowgraph.Subtract, nil).
+			ConnectSources(nil, oneval)
+		outer.Loop()
+
+		st := &sinkDuringZero{t, fmt.Sprintf("During6(depth %d)", depth), 0}
+		fg.NewHub("sink", flowgraph.Sink, st).
+			ConnectSources(everyval)
+
+		fg.Run()
+
+		fmt.Printf("// SINKS: %d\n", st.c)
+		if st.c == 0 {
+			t.Fatalf("ERROR %s sank nothing\n", st.name)
+		}
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestDuring6\n")
+}
+
+/*=====================================================================*/
+
+/* TestGCD Flowgraph HDL *
+
+rand100()(mval)
+rand100()(nval)
+while(mval, nval)(tcond, gcd) {
+        pass(mval)(gcd)
+        mod(nval, mval)(tcond)
+}
+sink(gcd)()
+sink2(tcond)()
+
+*/
+
+type rand100 struct {
+	init bool
+}
+
+func (t *rand100) Retrieve(n flowgraph.Hub) (result interface{}, err error) {
+	if !(*t).init {
+		// (*t).init = true
+		return rand.Intn(100) + 1, nil
+	}
+	return flowgraph.EOS, flowgraph.EOS
+}
+
+func TestGCD(t *testing.T) {
+	fmt.Printf("BEGIN:  TestGCD\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second * 1
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestGCD")
+
+	mval := fg.NewPipe("mval")
+	nval := fg.NewPipe("nval")
+	tcond := fg.NewPipe("tcond")
+	gcd := fg.NewPipe("gcd")
+
+	fg.NewHub("rand100", flowgraph.Retrieve, &rand100{}).
+		ConnectResults(mval)
+	fg.NewHub("rand100", flowgraph.Retrieve, &rand100{}).
+		ConnectResults(nval)
+
+	while := fg.NewGraphHub("while", flowgraph.While)
+	while.ConnectSources(mval, nval).
+		ConnectResults(tcond, gcd)
+
+	passm := while.NewHub("passm", flowgraph.Pass, nil)
+	mod := while.NewHub("mod", flowgraph.Modulo, nil)
+	while.ExposeResult(while.Connect(passm, 0, mod, 1))
+
+	while.Loop()
+
+	fg.NewHub("sink", flowgraph.Sink, nil).
+		ConnectSources(gcd)
+	fg.NewHub("sink2", flowgraph.Sink, nil).
+		ConnectSources(tcond)
+
+	fg.Run()
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestGCD\n")
+	// fmt.Printf("RESULTS %d,%d\n", fgbase.ChannelSize, while.Hub(3).Base().(*fgbase.Node).Cnt)
+}
+
+/*=====================================================================*/
+
+type cycle struct {
+	vals []interface{}
+	i    int
+}
+
+func (c *cycle) Retrieve(h flowgraph.Hub) (result interface{}, err error) {
+	c.i++
+	return c.vals[(c.i-1)%len(c.vals)], nil
+}
+
+type collect struct {
+	vals []interface{}
+}
+
+func (c *collect) Sink(source []interface{}) {
+	if source[0] != flowgraph.EOS {
+		c.vals = append(c.vals, source[0])
+	}
+}
+
+// gcdMs and gcdNs are pairs of values with greatest common divisors gcds
+var (
+	gcdMs = []interface{}{12, 35, 9, 100}
+	gcdNs = []interface{}{18, 14, 28, 75}
+	gcds  = []interface{}{6, 7, 1, 25}
+)
+
+// gcdLoop builds the While loop of TestGCD fed by cycles of m and n
+// values, with its gcd results sunk into c, or left on pipe "gcd" for
+// the caller if c is nil, and returns the loop
+func gcdLoop(fg flowgraph.Flowgraph, marr, narr *cycle, c *collect) flowgraph.GraphHub {
+	mval := fg.NewPipe("mval")
+	nval := fg.NewPipe("nval")
+	tcond := fg.NewPipe("tcond")
+	gcd := fg.NewPipe("gcd")
+
+	fg.NewHub("marr", flowgraph.Retrieve, marr).
+		ConnectResults(mval)
+	fg.NewHub("narr", flowgraph.Retrieve, narr).
+		ConnectResults(nval)
+
+	while := fg.NewGraphHub("while", flowgraph.While)
+	while.ConnectSources(mval, nval).
+		ConnectResults(tcond, gcd)
+
+	passm := while.NewHub("passm", flowgraph.Pass, nil)
+	mod := while.NewHub("mod", flowgraph.Modulo, nil)
+	while.ExposeResult(while.Connect(passm, 0, mod, 1))
+
+	while.Loop()
+
+	if c != nil {
+		fg.NewHub("sink", flowgraph.Sink, c).
+			ConnectSources(gcd)
+	}
+	fg.NewHub("sink2", flowgraph.Sink, nil).
+		ConnectSources(tcond)
+	return while
+}
+
+/* TestCapacity Flowgraph HDL *
+
+marr()(mval)
+narr()(nval)
+while(mval, nval)(tcond, gcd) {
+        pass(mval)(gcd)
+        mod(nval, mval)(tcond)
+}
+sink(gcd)()
+sink2(tcond)()
+
+*/
+
+func TestCapacity(t *testing.T) {
+	fmt.Printf("BEGIN:  TestCapacity\n")
+	oldChannelSize := fgbase.ChannelSize
+	fgbase.ChannelSize = 4
+	o := flowgraph.DefaultOptions()
+	o.RunTime = time.Second / 4
+	o.TraceLevel = fgbase.Q
+
+	// one value at a time keeps the gcd results in order, more lets the
+	// ones with fewer iterations pass the others
+	for _, depth := range []int{1, 4} {
+		fg := flowgraph.NewWithOptions("TestCapacity", o)
+		c := &collect{}
+		while := gcdLoop(fg, &cycle{vals: gcdMs}, &cycle{vals: gcdNs}, c)
+		while.SetLoopCapacity(depth)
+
+		if n := fg.FindPipe("nval").Capacity(); n != fgbase.ChannelSize {
+			t.Fatalf("ERROR default capacity %d, expected %d\n", n, fgbase.ChannelSize)
+		}
+		if m := fg.FindPipe("mval").SetCapacity(8); m.Capacity() != 4 || m.Base().(*fgbase.Edge).RdyCnt != 4 {
+			t.Fatalf("ERROR mval capacity %d, expected 8 limited to fgbase.ChannelSize\n", m.Capacity())
+		}
+		if n := while.FindHub("whileWait").Result(0).Capacity(); n != depth {
+			t.Fatalf("ERROR loop capacity %d, expected %d\n", n, depth)
+		}
+
+		fg.Run()
+
+		inOrder := len(c.vals) > 0
+		for i, v := range c.vals {
+			if v != gcds[i%len(gcds)] {
+				inOrder = false
+			}
+		}
+		if inOrder != (depth == 1) {
+			t.Fatalf("ERROR loop capacity %d sank %d values in order %v\n", depth, len(c.vals), inOrder)
+		}
+	}
+
+	fgbase.ChannelSize = oldChannelSize
+	fmt.Printf("END:    TestCapacity\n")
+}
+
+/*=====================================================================*/
+
+/* TestGoRound Flowgraph HDL *
+
+ival,jval,kval,lval,mval,nval=tbcar(),tbcar(),tbcar(),tbcar(),tbcar(),tbcar()
+while(ival,jval,kval,lval,mval,nval)(iout,jout,kout,lout,mout,nout) {
+        iout,jout,kout,lout,mout,nout=onelap(ival,jval,kval,lval,mval,nval)
+}
+sink(iout)
+sink(jout)
+sink(kout)
+sink(lout)
+sink(mout)
+sink(nout)
+
+*/
+
+type car struct {
+	number   int64
+	distance int
+	winner   int
+}
+
+func (c *car) Break() bool {
+	return c.winner != -1
+}
+
+type tbcar struct {
+}
+
+func (t *tbcar) Retrieve(h flowgraph.Hub) (result interface{}, err error) {
+	return &car{h.Base().(*fgbase.Node).ID, rand.Intn(100) + 1, -1}, nil
+}
+
+type onelap struct{}
+
+func (l *onelap) Transform(n flowgraph.Hub, source []interface{}) (result []interface{}, err error) {
+	result = make([]interface{}, len(source))
+	for i := range source {
+		cprev := source[i].(*car)
+		cnext := &car{cprev.number, max(0, cprev.distance-rand.Intn(6)+1), -1}
+		result[i] = cnext
+		if cnext.distance == 0 {
+			czero := result[0].(*car)
+			czero.winner = i
+		}
+	}
+	return
+}
+
+func TestGoRound(t *testing.T) {
+	fmt.Printf("BEGIN:  TestGoRound\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestGoRound")
+
+	ival := fg.NewPipe("ival")
+	jval := fg.NewPipe("jval")
+	kval := fg.NewPipe("kval")
+	lval := fg.NewPipe("lval")
+	mval := fg.NewPipe("mval")
+	nval := fg.NewPipe("nval")
+
+	iout := fg.NewPipe("iout")
+	jout := fg.NewPipe("jout")
+	kout := fg.NewPipe("kout")
+	lout := fg.NewPipe("lout")
+	mout := fg.NewPipe("mout")
+	nout := fg.NewPipe("nout")
+
+	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
+		ConnectResults(ival)
+	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
+		ConnectResults(jval)
+	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
+		ConnectResults(kval)
+	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
+		ConnectResults(lval)
+	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
+		ConnectResults(mval)
+	fg.NewHub("tbcar", flowgraph.Retrieve, &tbcar{}).
+		ConnectResults(nval)
+
+	while := fg.NewGraphHub("while", flowgraph.While)
+	while.ConnectSources(ival, jval, kval, lval, mval, nval).
+		ConnectResults(iout, jout, kout, lout, mout, nout)
+
+	while.NewHub("onelap", flowgraph.AllOf, &onelap{}).
+		SetNumSource(6).
+		SetNumResult(6)
+
+	while.Loop()
+
+	fg.NewHub("sinki", flowgraph.Sink, nil).
+		ConnectSources(iout)
+	fg.NewHub("sinkj", flowgraph.Sink, nil).
+		ConnectSources(jout)
+	fg.NewHub("sinkk", flowgraph.Sink, nil).
+		ConnectSources(kout)
+	fg.NewHub("sinkl", flowgraph.Sink, nil).
+		ConnectSources(lout)
+	fg.NewHub("sinkm", flowgraph.Sink, nil).
+		ConnectSources(mout)
+	fg.NewHub("sinkn", flowgraph.Sink, nil).
+		ConnectSources(nout)
+
+	fg.Run()
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestGoRound\n")
+}
+
+/*=====================================================================*/
+
+/* TestCross Flowgraph HDL *
+
+ival,jval=tbtoggle(),rand100()
+cross(ival,jval)(iout,jout)
+sink(iout)
+sink(kout)
+
+*/
+
+type tbtoggle struct {
+	last bool
+}
+
+func (t *tbtoggle) Retrieve(n flowgraph.Hub) (result interface{}, err error) {
+	f := t.last
+	t.last = !f
+	return f, nil
+}
+
+func TestCross(t *testing.T) {
+	fmt.Printf("BEGIN:  TestCross\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestCross")
+
+	ival := fg.NewPipe("ival")
+	jval := fg.NewPipe("jval")
+	kval := fg.NewPipe("kval")
+	lval := fg.NewPipe("lval")
+	iout := fg.NewPipe("iout")
+	jout := fg.NewPipe("jout")
+	kout := fg.NewPipe("kout")
+	lout := fg.NewPipe("lout")
+
+	fg.NewHub("tbtoggle", flowgraph.Retrieve, &tbtoggle{}).
+		ConnectResults(ival)
+	fg.NewHub("rand100", flowgraph.Retrieve, &rand100{}).
+		ConnectResults(jval)
+	fg.NewHub("tbtoggle", flowgraph.Retrieve, &tbtoggle{}).
+		ConnectResults(kval)
+	fg.NewHub("rand100", flowgraph.Retrieve, &rand100{}).
+		ConnectResults(lval)
+
+	fg.NewHub("cross", flowgraph.Cross, nil).
+		ConnectSources(ival, jval, kval, lval).
+		ConnectResults(iout, jout, kout, lout)
+
+	fg.NewHub("sinki", flowgraph.Sink, nil).
+		ConnectSources(iout)
+	fg.NewHub("sinkj", flowgraph.Sink, nil).
+		ConnectSources(jout)
+	fg.NewHub("sinkk", flowgraph.Sink, nil).
+		ConnectSources(kout)
+	fg.NewHub("sinkl", flowgraph.Sink, nil).
+		ConnectSources(lout)
+
+	fg.Run()
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestCross\n")
+}
+
+/*=====================================================================*/
+
+/* TestSelect Flowgraph HDL *
+
+sel,aval,bval,cval=tbsel(),tba(),tbb(),tbc()
+select(sel,aval,bval,cval)(xval)
+sink(xval)()
+
+*/
+
+type sinkValues struct {
+	t    *testing.T
+	name string
+	gt   []interface{}
+}
+
+func (st *sinkValues) Sink(source []interface{}) {
+	if len(st.gt) == 0 {
+		st.t.Fatalf("ERROR %s unexpected result %v\n", st.name, source[0])
+	}
+	if source[0] != st.gt[0] {
+		st.t.Fatalf("ERROR %s result is %v, expected %v\n", st.name, source[0], st.gt[0])
+	}
+	st.gt = st.gt[1:]
+}
+
+func TestSelect(t *testing.T) {
+	fmt.Printf("BEGIN:  TestSelect\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestSelect")
+
+	sel := fg.NewPipe("sel")
+	aval := fg.NewPipe("aval")
+	bval := fg.NewPipe("bval")
+	cval := fg.NewPipe("cval")
+	xval := fg.NewPipe("xval")
+
+	fg.NewHub("tbsel", flowgraph.Array, []interface{}{0, 2, 1, 0, uint8(2), true}).
+		ConnectResults(sel)
+	fg.NewHub("tba", flowgraph.Array, []interface{}{10, 11}).
+		ConnectResults(aval)
+	fg.NewHub("tbb", flowgraph.Array, []interface{}{20, 21}).
+		ConnectResults(bval)
+	fg.NewHub("tbc", flowgraph.Array, []interface{}{30, 31}).
+		ConnectResults(cval)
+
+	fg.NewHub("select", flowgraph.Select, nil).
+		ConnectSources(sel, aval, bval, cval).
+		ConnectResults(xval)
+
+	st := &sinkValues{t, "Select", []interface{}{10, 30, 20, 11, 31, 21}}
+	fg.NewHub("sink", flowgraph.Sink, st).
+		ConnectSources(xval)
+
+	fg.Run()
+
+	if len(st.gt) != 0 {
+		t.Fatalf("ERROR Select missing results %v\n", st.gt)
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestSelect\n")
+}
+
+/*=====================================================================*/
+
+/* TestSelectRange Flowgraph HDL *
+
+sel,aval,bval=tbsel(),tba(),tbb()
+select(sel,aval,bval)(xval)
+sink(xval)()
+
+*/
+
+func TestSelectRange(t *testing.T) {
+	fmt.Printf("BEGIN:  TestSelectRange\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestSelectRange")
+
+	sel := fg.NewPipe("sel")
+	aval := fg.NewPipe("aval")
+	bval := fg.NewPipe("bval")
+	xval := fg.NewPipe("xval")
+
+	// out-of-range and non-integer selectors are consumed without draining
+	// a data source and returned as errors, and EOS on the selector ends
+	// the stream
+	fg.NewHub("tbsel", flowgraph.Array, []interface{}{1, 2, -1, "b", 0, 1}).
+		ConnectResults(sel)
+	fg.NewHub("tba", flowgraph.Array, []interface{}{10, 11, 12}).
+		ConnectResults(aval)
+	fg.NewHub("tbb", flowgraph.Array, []interface{}{20, 21, 22}).
+		ConnectResults(bval)
+
+	fg.NewHub("select", flowgraph.Select, nil).
+		ConnectSources(sel, aval, bval).
+		ConnectResults(xval)
+
+	s := &fgbase.SinkStats{}
+	fg.NewHub("sink", flowgraph.Sink, s).
+		ConnectSources(xval)
+
+	err := fg.Run()
+
+	if err == nil || len(strings.Split(err.Error(), "\n")) != 3 || !strings.Contains(err.Error(), "out of range") {
+		t.Fatalf("ERROR SelectRange returned %v, expected 3 out of range errors\n", err)
+	}
+	if s.Cnt != 3 {
+		t.Fatalf("SinkStats.Cnt %d != 3\n", s.Cnt)
+	}
+	if s.Sum != 20+10+21 {
+		t.Fatalf("SinkStats.Sum %d != %d\n", s.Sum, 20+10+21)
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestSelectRange\n")
+}
+
+/*=====================================================================*/
+
+/* TestSplit Flowgraph HDL *
+
+tbslice()(sval)
+split(sval)(xval,yval,zval)
+sinkx(xval)()
+sinky(yval)()
+sinkz(zval)()
+
+*/
+
+func TestSplit(t *testing.T) {
+	fmt.Printf("BEGIN:  TestSplit\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TraceLevel = fgbase.V
+
+	for _, pad := range []interface{}{nil, 100} {
+
+		fg := flowgraph.New("TestSplit")
+
+		sval := fg.NewPipe("sval")
+		xval := fg.NewPipe("xval")
+		yval := fg.NewPipe("yval")
+		zval := fg.NewPipe("zval")
+
+		fg.NewHub("tbslice", flowgraph.Array, []interface{}{
+			[]interface{}{1, 2, 3},
+			[]interface{}{4, 5},       // padded or dropped
+			[]interface{}{6, 7, 8, 9}, // always dropped
+			7,                         // always dropped
+			[]interface{}{10, 20, 30},
+		}).ConnectResults(sval)
+
+		fg.NewHub("split", flowgraph.Split, pad).
+			ConnectSources(sval).
+			ConnectResults(xval, yval, zval)
+
+		sx, sy, sz := &fgbase.SinkStats{}, &fgbase.SinkStats{}, &fgbase.SinkStats{}
+		fg.NewHub("sinkx", flowgraph.Sink, sx).ConnectSources(xval)
+		fg.NewHub("sinky", flowgraph.Sink, sy).ConnectSources(yval)
+		fg.NewHub("sinkz", flowgraph.Sink, sz).ConnectSources(zval)
+
+		err := fg.Run()
+
+		gtx, gty, gtz := 1+10, 2+20, 3+30
+		gtcnt, gterr := 2, 3
+		if pad != nil {
+			gtx, gty, gtz = gtx+4, gty+5, gtz+100
+			gtcnt++
+			gterr--
+		}
+		if err == nil || len(strings.Split(err.Error(), "\n")) != gterr || !strings.Contains(err.Error(), "split") {
+			t.Fatalf("ERROR Split (pad %v) returned %v, expected %d errors\n", pad, err, gterr)
+		}
+		if sx.Cnt != gtcnt || sy.Cnt != gtcnt || sz.Cnt != gtcnt {
+			t.Fatalf("ERROR Split (pad %v) counts %d,%d,%d != %d\n", pad, sx.Cnt, sy.Cnt, sz.Cnt, gtcnt)
+		}
+		if sx.Sum != gtx || sy.Sum != gty || sz.Sum != gtz {
+			t.Fatalf("ERROR Split (pad %v) sums %d,%d,%d != %d,%d,%d\n", pad, sx.Sum, sy.Sum, sz.Sum, gtx, gty, gtz)
+		}
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestSplit\n")
+}
+
+/*=====================================================================*/
+
+/* TestJoin Flowgraph HDL *
+
+xval,yval,zval=tbx(),tby(),tbz()
+join(xval,yval,zval)(sval)
+sink(sval)()
+
+*/
+
+type sinkJoin struct {
+	t  *testing.T
+	gt []string
+}
+
+func (st *sinkJoin) Sink(source []interface{}) {
+	if len(st.gt) == 0 {
+		st.t.Fatalf("ERROR Join unexpected result %v\n", source[0])
+	}
+	if fmt.Sprint(source[0]) != st.gt[0] {
+		st.t.Fatalf("ERROR Join result is %v, expected %s\n", source[0], st.gt[0])
+	}
+	st.gt = st.gt[1:]
+}
+
+func TestJoin(t *testing.T) {
+	fmt.Printf("BEGIN:  TestJoin\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestJoin")
+
+	xval := fg.NewPipe("xval")
+	yval := fg.NewPipe("yval")
+	zval := fg.NewPipe("zval")
+	sval := fg.NewPipe("sval")
+
+	// EOS on the shortest source ends the joined stream
+	fg.NewHub("tbx", flowgraph.Array, []interface{}{1, 4, 7}).
+		ConnectResults(xval)
+	fg.NewHub("tby", flowgraph.Array, []interface{}{2, 5}).
+		ConnectResults(yval)
+	fg.NewHub("tbz", flowgraph.Array, []interface{}{3, 6, 9}).
+		ConnectResults(zval)
+
+	fg.NewHub("join", flowgraph.Join, nil).
+		ConnectSources(xval, yval, zval).
+		ConnectResults(sval)
+
+	st := &sinkJoin{t, []string{"[1 2 3]", "[4 5 6]"}}
+	fg.NewHub("sink", flowgraph.Sink, st).
+		ConnectSources(sval)
+
+	fg.Run()
+
+	if len(st.gt) != 0 {
+		t.Fatalf("ERROR Join missing results %v\n", st.gt)
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestJoin\n")
+}
+
+/*=====================================================================*/
+
+/* TestLogic Flowgraph HDL *
+
+aval,bval=tba(),tbb()
+and(aval,bval)(andval)
+or(aval,bval)(orval)
+not(aval)(notval)
+sinkand(andval)()
+sinkor(orval)()
+sinknot(notval)()
+
+*/
+
+func TestLogic(t *testing.T) {
+	fmt.Printf("BEGIN:  TestLogic\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestLogic")
+
+	aval := fg.NewPipe("aval")
+	bval := fg.NewPipe("bval")
+	andval := fg.NewPipe("andval")
+	orval := fg.NewPipe("orval")
+	notval := fg.NewPipe("notval")
+
+	// the last pair is of mismatched types, an error for And and Or
+	fg.NewHub("tba", flowgraph.Array, []interface{}{true, uint8(0xf0), int16(-1), int64(0x0ff0), uint32(0x1), uint8(0x1)}).
+		ConnectResults(aval)
+	fg.NewHub("tbb", flowgraph.Array, []interface{}{false, uint8(0x3c), int16(0x0f0f), int64(0x00ff), uint32(0x3), uint64(0x1)}).
+		ConnectResults(bval)
+
+	fg.NewHub("and", flowgraph.And, nil).
+		ConnectSources(aval, bval).
+		ConnectResults(andval)
+	fg.NewHub("or", flowgraph.Or, nil).
+		ConnectSources(aval, bval).
+		ConnectResults(orval)
+	fg.NewHub("not", flowgraph.Not, nil).
+		ConnectSources(aval).
+		ConnectResults(notval)
+
+	sinks := []*sinkValues{
+		{t, "And", []interface{}{false, uint8(0x30), int16(0x0f0f), int64(0x00f0), uint32(0x1)}},
+		{t, "Or", []interface{}{true, uint8(0xfc), int16(-1), int64(0x0fff), uint32(0x3)}},
+		{t, "Not", []interface{}{false, uint8(0x0f), int16(0), int64(^0x0ff0), uint32(0xfffffffe), uint8(0xfe)}},
+	}
+	fg.NewHub("sinkand", flowgraph.Sink, sinks[0]).ConnectSources(andval)
+	fg.NewHub("sinkor", flowgraph.Sink, sinks[1]).ConnectSources(orval)
+	fg.NewHub("sinknot", flowgraph.Sink, sinks[2]).ConnectSources(notval)
+
+	err := fg.Run()
+
+	if err == nil || len(strings.Split(err.Error(), "\n")) != 2 || !strings.Contains(err.Error(), "mismatched") {
+		t.Fatalf("ERROR Logic returned %v, expected 2 mismatched type errors\n", err)
+	}
+
+	for _, st := range sinks {
+		if len(st.gt) != 0 {
+			t.Fatalf("ERROR %s missing results %v\n", st.name, st.gt)
+		}
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestLogic\n")
+}
+
+/*=====================================================================*/
+
+/* TestShift Flowgraph HDL *
+
+aval,bval=tba(),tbb()
+shift(aval,bval)(xval)
+sink(xval)()
+
+*/
+
+type xorer struct{}
+
+func (x *xorer) Transform(h flowgraph.Hub, source []interface{}) (result []interface{}, err error) {
+	if h.HubCode() != flowgraph.Shift {
+		h.Panicf("xorer used with %s HubCode\n", h.HubCode())
+	}
+	return []interface{}{source[0].(uint8) ^ uint8(source[1].(int))}, nil
+}
+
+func TestShift(t *testing.T) {
+	fmt.Printf("BEGIN:  TestShift\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TraceLevel = fgbase.V
+
+	aarr := []interface{}{uint8(0x81), uint8(0x81), int8(-128), int8(-128), uint8(0x80), int32(1)}
+	barr := []interface{}{1, -1, -1, -9, -2, 31}
+
+	tests := []struct {
+		init interface{}
+		gt   []interface{}
+	}{
+		{nil, []interface{}{uint8(0x02), uint8(0x40), int8(0x40), int8(0), uint8(0x20), int32(-1 << 31)}},
+		{flowgraph.Arith, []interface{}{uint8(0x02), uint8(0x40), int8(0x40), int8(0), uint8(0x20), int32(-1 << 31)}},
+		{flowgraph.Barrel, []interface{}{uint8(0x03), uint8(0xc0), int8(0x40), int8(0x40), uint8(0x20), int32(-1 << 31)}},
+		{flowgraph.Signed, []interface{}{uint8(0x02), uint8(0xc0), int8(-64), int8(-1), uint8(0xe0), int32(-1 << 31)}},
+		{&xorer{}, []interface{}{uint8(0x80), uint8(0x7e)}},
+	}
+
+	for _, test := range tests {
+
+		fg := flowgraph.New("TestShift")
+
+		aval := fg.NewPipe("aval")
+		bval := fg.NewPipe("bval")
+		xval := fg.NewPipe("xval")
+
+		n := len(test.gt)
+		fg.NewHub("tba", flowgraph.Array, aarr[:n]).
+			ConnectResults(aval)
+		fg.NewHub("tbb", flowgraph.Array, barr[:n]).
+			ConnectResults(bval)
+
+		fg.NewHub("shift", flowgraph.Shift, test.init).
+			ConnectSources(aval, bval).
+			ConnectResults(xval)
+
+		st := &sinkValues{t, fmt.Sprintf("Shift(%v)", test.init), test.gt}
+		fg.NewHub("sink", flowgraph.Sink, st).
+			ConnectSources(xval)
+
+		fg.Run()
+
+		if len(st.gt) != 0 {
+			t.Fatalf("ERROR %s missing results %v\n", st.name, st.gt)
+		}
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestShift\n")
+}
+
+/*=====================================================================*/
+
+/* TestRunContext Flowgraph HDL *
+
+array()(aval)
+add(aval, 1)(xval)
+sink(xval)()
+
+*/
+
+func TestRunContext(t *testing.T) {
+	fmt.Printf("BEGIN:  TestRunContext\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = 0
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestRunContext")
+
+	aval := fg.NewPipe("aval")
+	xval := fg.NewPipe("xval")
+
+	fg.NewHub("array", flowgraph.Array, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}).
+		ConnectResults(aval)
+	fg.NewHub("add", flowgraph.Add, nil).
+		ConnectSources(aval, fg.NewPipe("oneval").Const(1)).
+		ConnectResults(xval)
+
+	s := &fgbase.SinkStats{}
+	fg.NewHub("sink", flowgraph.Sink, s).
+		ConnectSources(xval)
+
+	if err := fg.RunContext(context.Background()); err != nil {
+		t.Fatalf("ERROR RunContext returned %v\n", err)
+	}
+	if s.Cnt != 10 || s.Sum != 55 {
+		t.Fatalf("ERROR RunContext drained %d values summing to %d\n", s.Cnt, s.Sum)
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestRunContext\n")
+}
+
+/*=====================================================================*/
+
+/* TestRunContextCancel Flowgraph HDL *
+
+ten()(firstval)
+while(firstval)(lastval) {
+        sub(firstval, 1)(lastval)
+}
+sink(lastval)()
+
+*/
+
+func TestRunContextCancel(t *testing.T) {
+	fmt.Printf("BEGIN:  TestRunContextCancel\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = 0
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestRunContextCancel")
+
+	firstval := fg.NewPipe("firstval")
+	lastval := fg.NewPipe("lastval")
+
+	fg.NewHub("ten", flowgraph.Constant, 10).
+		ConnectResults(firstval)
+
+	while := fg.NewGraphHub("while", flowgraph.While)
+	while.ConnectSources(firstval).
+		ConnectResults(lastval)
+	while.NewHub("sub", flowgraph.Subtract, nil).
+		ConnectSources(nil, while.NewPipe("oneval").Const(1))
+	while.Loop()
+
+	fg.NewHub("sink", flowgraph.Sink, &sinkIterator3{t}).
+		ConnectSources(lastval)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/10)
+	defer cancel()
+	if err := fg.RunContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("ERROR RunContextCancel returned %v\n", err)
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestRunContextCancel\n")
+}
+
+/*=====================================================================*/
+
+// settledGoroutines returns the count of goroutines once it falls to
+// before, or after a second of waiting for goroutines on their way out
+func settledGoroutines(before int) int {
+	n := runtime.NumGoroutine()
+	for end := time.Now().Add(time.Second); n > before && time.Now().Before(end); n = runtime.NumGoroutine() {
+		time.Sleep(time.Millisecond)
+	}
+	return n
+}
+
+/* TestRunContextBlocked Flowgraph HDL *
+
+array()(aval)
+one()(oneval)
+steer(oneval)(bval,dropval)
+add(aval,bval)(xval)
+sink(xval)()
+drop(dropval)()
+
+*/
+
+// TestRunContextBlocked cancels a run with hubs waiting on a source that
+// never gets a value, and checks RunContext returns with every hub done
+func TestRunContextBlocked(t *testing.T) {
+	fmt.Printf("BEGIN:  TestRunContextBlocked\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = 0
+	fgbase.TraceLevel = fgbase.Q
+
+	for _, e := range []flowgraph.Executor{flowgraph.Goroutines, flowgraph.Clocked, flowgraph.Sequential, flowgraph.WorkerPool} {
+		o := flowgraph.DefaultOptions()
+		o.Executor = e
+		fg := flowgraph.NewWithOptions("TestRunContextBlocked", o)
+
+		aval := fg.NewPipe("aval")
+		oneval := fg.NewPipe("oneval")
+		bval := fg.NewPipe("bval")
+		dropval := fg.NewPipe("dropval")
+		xval := fg.NewPipe("xval")
+
+		fg.NewHub("array", flowgraph.Array, []interface{}{1, 2, 3}).
+			ConnectResults(aval)
+		fg.NewHub("one", flowgraph.Constant, 1).
+			ConnectResults(oneval)
+		fg.NewHub("steer", flowgraph.Steer, nil).
+			ConnectSources(oneval).
+			ConnectResults(bval, dropval) // never on bval
+		fg.NewHub("add", flowgraph.Add, nil).
+			ConnectSources(aval, bval).
+			ConnectResults(xval)
+		s := &fgbase.SinkStats{}
+		fg.NewHub("sink", flowgraph.Sink, s).
+			ConnectSources(xval)
+		fg.NewHub("drop", flowgraph.Sink, nil).
+			ConnectSources(dropval)
+
+		before := runtime.NumGoroutine()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second/10)
+		ran := make(chan error)
+		go func() { ran <- fg.RunContext(ctx) }()
+		select {
+		case err := <-ran:
+			if err != context.DeadlineExceeded || s.Cnt != 0 {
+				t.Fatalf("ERROR RunContextBlocked with %v executor returned %v with %d values\n", e, err, s.Cnt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ERROR RunContextBlocked with %v executor never returned\n", e)
+		}
+		cancel()
+		if after := settledGoroutines(before); after > before {
+			t.Fatalf("ERROR RunContextBlocked with %v executor left %d goroutines running\n", e, after-before)
+		}
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestRunContextBlocked\n")
+}
+
+/*=====================================================================*/
+
+/* TestRunContextLoop Flowgraph HDL *
+
+marr()(mval)
+narr()(nval)
+while(mval, nval)(tcond, gcd) {
+        pass(mval)(gcd)
+        mod(nval, mval)(tcond)
+}
+sink(gcd)()
+sink2(tcond)()
+
+*/
+
+func TestRunContextLoop(t *testing.T) {
+	fmt.Printf("BEGIN:  TestRunContextLoop\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	oldChannelSize := fgbase.ChannelSize
+	fgbase.RunTime = 0
+	fgbase.TraceLevel = fgbase.Q
+	fgbase.ChannelSize = 4 // several values in the loop at once
+
+	fg := flowgraph.New("TestRunContextLoop")
+	marr, narr, c := &cycle{vals: gcdMs}, &cycle{vals: gcdNs}, &collect{}
+	gcdLoop(fg, marr, narr, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/10)
+	defer cancel()
+	if err := fg.RunContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("ERROR RunContextLoop returned %v\n", err)
+	}
+
+	// every pair let into the loop comes out of it, in any order
+	want := make(map[interface{}]int)
+	for i := 0; i < min(marr.i, narr.i); i++ {
+		want[gcds[i%len(gcds)]]++
+	}
+	got := make(map[interface{}]int)
+	for _, v := range c.vals {
+		got[v]++
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ERROR RunContextLoop sank %v of %d and %d values, expected %v\n", got, marr.i, narr.i, want)
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fgbase.ChannelSize = oldChannelSize
+	fmt.Printf("END:    TestRunContextLoop\n")
+}
+
+/*=====================================================================*/
+
+/* TestRunLeak Flowgraph HDL *
+
+one()(x)
+sink(x)()
+
+*/
+
+// TestRunLeak runs a flowgraph for a hundredth of a second twenty times
+// over, and checks no goroutines are left behind
+func TestRunLeak(t *testing.T) {
+	fmt.Printf("BEGIN:  TestRunLeak\n")
+	o := flowgraph.DefaultOptions()
+	o.RunTime = time.Second / 100
+	o.TraceLevel = fgbase.Q
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		fg := flowgraph.NewWithOptions("TestRunLeak", o)
+		x := fg.NewPipe("x")
+		fg.NewHub("one", flowgraph.Constant, 1).
+			ConnectResults(x)
+		fg.NewHub("sink", flowgraph.Sink, nil).
+			ConnectSources(x)
+		if err := fg.Run(); err != nil {
+			t.Fatalf("ERROR RunLeak Run returned %v\n", err)
+		}
+	}
+	if after := settledGoroutines(before); after > before {
+		t.Fatalf("ERROR RunLeak left %d goroutines running after 20 runs\n", after-before)
+	}
+	fmt.Printf("END:    TestRunLeak\n")
+}
+
+/*=====================================================================*/
+
+/* TestRunContextError Flowgraph HDL *
+
+failer()(xval)
+sink(xval)()
+
+*/
+
+var errFailer = errors.New("failer failed")
+
+type failer struct {
+	i int
+}
+
+func (f *failer) Retrieve(n flowgraph.Hub) (result interface{}, err error) {
+	f.i++
+	switch {
+	case f.i <= 3:
+		return f.i, nil
+	case f.i == 4:
+		return nil, errFailer
+	}
+	return flowgraph.EOS, flowgraph.EOS
+}
+
+func TestRunContextError(t *testing.T) {
+	fmt.Printf("BEGIN:  TestRunContextError\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = 0
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestRunContextError")
+
+	xval := fg.NewPipe("xval")
+	fg.NewHub("failer", flowgraph.Retrieve, &failer{}).
+		ConnectResults(xval)
+	s := &fgbase.SinkStats{}
+	fg.NewHub("sink", flowgraph.Sink, s).
+		ConnectSources(xval)
+
+	err := fg.RunContext(context.Background())
+	if !errors.Is(err, errFailer) || !strings.Contains(err.Error(), "failer") {
+		t.Fatalf("ERROR RunContextError returned %v\n", err)
+	}
+	if s.Cnt != 3 {
+		t.Fatalf("ERROR RunContextError sank %d values\n", s.Cnt)
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestRunContextError\n")
+}
+
+/*=====================================================================*/
+
+/* TestErrorPolicy Flowgraph HDL *
+
+array()(aval)
+double(aval)(xval[,.error(errval)])
+sink(xval)()
+[sink(errval)()]
+
+*/
+
+var errFlaky = errors.New("flaky failed")
+
+// flakyDouble always fails on 3, and fails on 5 the first time only
+type flakyDouble struct {
+	tries map[int]int
+}
+
+func (f *flakyDouble) Transform(n flowgraph.Hub, source []interface{}) (result []interface{}, err error) {
+	v, ok := source[0].(int)
+	if !ok {
+		return source, nil
+	}
+	f.tries[v]++
+	if v == 3 || v == 5 && f.tries[v] == 1 {
+		return nil, errFlaky
+	}
+	return []interface{}{v * 2}, nil
+}
+
+func TestErrorPolicy(t *testing.T) {
+	fmt.Printf("BEGIN:  TestErrorPolicy\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = 0
+	fgbase.TraceLevel = fgbase.V
+
+	tests := []struct {
+		policy flowgraph.ErrorPolicy
+		gt     []interface{}
+		nerr   int
+	}{
+		{flowgraph.ErrorPolicy{}, []interface{}{2, 4, 8, 12}, 2},
+		{flowgraph.ErrorPolicy{Code: flowgraph.FailOnError}, []interface{}{2, 4}, 1},
+		{flowgraph.ErrorPolicy{Code: flowgraph.RetryOnError, Retries: 2, Backoff: time.Millisecond}, []interface{}{2, 4}, 1},
+		{flowgraph.ErrorPolicy{Code: flowgraph.ResultOnError}, []interface{}{2, 4, 8, 12}, 2},
+	}
+
+	for _, test := range tests {
+
+		fg := flowgraph.New("TestErrorPolicy")
+
+		aval := fg.NewPipe("aval")
+		xval := fg.NewPipe("xval")
+
+		fg.NewHub("array", flowgraph.Array, []interface{}{1, 2, 3, 4, 5, 6}).
+			ConnectResults(aval)
+
+		double := fg.NewHub("double", flowgraph.AllOf, &flakyDouble{map[int]int{}}).
+			ConnectSources(aval).
+			ConnectResults(xval)
+
+		name := fmt.Sprintf("ErrorPolicy(%s)", test.policy.Code)
+		st := &sinkValues{t, name, test.gt}
+		fg.NewHub("sink", flowgraph.Sink, st).
+			ConnectSources(xval)
+
+		var es *fgbase.SinkStats
+		if test.policy.Code == flowgraph.ResultOnError {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Fatalf("ERROR %s without an error port did not panic\n", name)
+					}
+				}()
+				double.SetErrorPolicy(test.policy)
+			}()
+			errval := fg.NewPipe("errval")
+			double.ConnectResults(xval, errval).
+				SetResultNames("x", flowgraph.ErrorPort)
+			es = &fgbase.SinkStats{}
+			fg.NewHub("errsink", flowgraph.Sink, es).
+				ConnectSources(errval)
+		}
+		double.SetErrorPolicy(test.policy)
+
+		err := fg.RunContext(context.Background())
+
+		if len(st.gt) != 0 {
+			t.Fatalf("ERROR %s missing results %v\n", name, st.gt)
+		}
+		if es != nil {
+			if err != nil || es.Cnt != test.nerr {
+				t.Fatalf("ERROR %s returned %v with %d errors on result\n", name, err, es.Cnt)
+			}
+			continue
+		}
+		if !errors.Is(err, errFlaky) || !strings.Contains(err.Error(), "double") {
+			t.Fatalf("ERROR %s returned %v\n", name, err)
+		}
+		if n := len(strings.Split(err.Error(), "\n")); n != test.nerr {
+			t.Fatalf("ERROR %s returned %d errors instead of %d\n", name, n, test.nerr)
+		}
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestErrorPolicy\n")
+}
+
+/*=====================================================================*/
+
+/* TestTypedPipe Flowgraph HDL *
+
+array()(aval)
+two()(bval)
+mul(aval, bval)(xval)
+sink(xval)()
+
+*/
+
+func TestTypedPipe(t *testing.T) {
+	fmt.Printf("BEGIN:  TestTypedPipe\n")
+	oldRunTime := fgbase.RunTime
+	oldTraceLevel := fgbase.TraceLevel
+	fgbase.RunTime = time.Second
+	fgbase.TraceLevel = fgbase.V
+
+	fg := flowgraph.New("TestTypedPipe")
+
+	aval := flowgraph.NewTypedPipe[int](fg, "aval")
+	bval := flowgraph.NewTypedPipe[int](fg, "bval")
+	xval := flowgraph.NewTypedPipe[int](fg, "xval")
+
+	fg.NewHub("array", flowgraph.Array, []interface{}{1, 2, 3, 4, 5}).
+		ConnectResults(aval)
+	fg.NewHub("two", flowgraph.Retrieve, flowgraph.RetrieverFunc[int](func(h flowgraph.Hub) (int, error) {
+		return 2, nil
+	})).ConnectResults(bval)
+	fg.NewHub("mul", flowgraph.AllOf, flowgraph.Func2[int, int, int](func(h flowgraph.Hub, a, b int) (int, error) {
+		return a * b, nil
+	})).ConnectSources(aval, bval).ConnectResults(xval)
+
+	st := &sinkValues{t, "TypedPipe", []interface{}{2, 4, 6, 8, 10}}
+	fg.NewHub("sink", flowgraph.Sink, st).
+		ConnectSources(xval)
+
+	fg.Run()
+
+	if len(st.gt) != 0 {
+		t.Fatalf("ERROR %s missing results %v\n", st.name, st.gt)
+	}
+
+	fgbase.RunTime = oldRunTime
+	fgbase.TraceLevel = oldTraceLevel
+	fmt.Printf("END:    TestTypedPipe\n")
+}
+
+func TestRetrieverFuncEOS(t *testing.T) {
+	fmt.Printf("BEGIN:  TestRetrieverFuncEOS\n")
+	n := 0
+	f := flowgraph.RetrieverFunc[int](func(h flowgraph.Hub) (int, error) {
+		if n++; n > 2 {
+			return 0, fmt.Errorf("done: %w", flowgraph.EOS)
+		}
+		return n, nil
+	})
+
+	for i := 1; i <= 3; i++ {
+		v, err := f.Retrieve(nil)
+		if i <= 2 && (v != i || err != nil) {
+			t.Fatalf("ERROR RetrieverFuncEOS retrieve %d got %v, %v\n", i, v, err)
+		}
+		if i == 3 && (v != flowgraph.EOS || err != flowgraph.EOS) {
+			t.Fatalf("ERROR RetrieverFuncEOS expected EOS, EOS at end of stream, got %v, %v\n", v, err)
+		}
+	}
+	fmt.Printf("END:    TestRetrieverFuncEOS\n")
+}
+
+func TestTypedPipeMismatch(t *testing.T) {
+	fmt.Printf("BEGIN:  TestTypedPipeMismatch\n")
+	double := flowgraph.Func1[int, int](func(h flowgraph.Hub, a int) (int, error) {
+		return a * 2, nil
+	})
+	hello := flowgraph.RetrieverFunc[string](func(h flowgraph.Hub) (string, error) {
+		return "hello", nil
+	})
+
+	tests := []struct {
+		name  string
+		build func(fg flowgraph.Flowgraph)
+	}{
+		{"TypedPipe into port", func(fg flowgraph.Flowgraph) {
+			fg.NewHub("double", flowgraph.AllOf, double).
+				ConnectSources(flowgraph.NewTypedPipe[string](fg, "sval"))
+		}},
+		{"port into TypedPipe", func(fg flowgraph.Flowgraph) {
+			fg.NewHub("hello", flowgraph.Retrieve, hello).
+				ConnectResults(flowgraph.NewTypedPipe[int](fg, "ival"))
+		}},
+		{"port into port", func(fg flowgraph.Flowgraph) {
+			fg.Connect(
+				fg.NewHub("hello", flowgraph.Retrieve, hello).SetNumResult(1), 0,
+				fg.NewHub("double", flowgraph.AllOf, double).SetNumSource(1), 0)
+		}},
+		{"Init", func(fg flowgraph.Flowgraph) {
+			flowgraph.NewTypedPipe[int](fg, "ival").Init("one")
+		}},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("ERROR TypedPipeMismatch %s did not panic\n", test.name)
+				}
+			}()
+			test.build(flowgraph.New("TestTypedPipeMismatch"))
+		}()
+	}
+	fmt.Printf("END:    TestTypedPipeMismatch\n")
+}
+
+/*=====================================================================*/
+
+/* TestValidate Flowgraph HDL *
+
+ten()(firstval)
+while(firstval)(lastval) {
+        sub(firstval, 1)(lastval)
+}
+sink(lastval)()
+
+*/
+
+func TestValidate(t *testing.T) {
+	fmt.Printf("BEGIN:  TestValidate\n")
+
+	fg := flowgraph.New("TestValidate")
+
+	firstval := fg.NewPipe("firstval")
+	lastval := fg.NewPipe("lastval")
+	fg.NewHub("ten", flowgraph.Constant, 10).
+		ConnectResults(firstval)
+	while := fg.NewGraphHub("while", flowgraph.While)
+	while.ConnectSources(firstval).
+		ConnectResults(lastval)
+	while.NewHub("sub", flowgraph.Subtract, nil).
+		SetSource(1, while.NewPipe("oneval").Const(1))
+	while.Loop()
+	fg.NewHub("sink", flowgraph.Sink, nil).
+		ConnectSources(lastval)
+
+	if err := fg.Validate(); err != nil {
+		t.Fatalf("ERROR Validate of a good flowgraph returned %v\n", err)
+	}
+
+	fg = flowgraph.New("TestValidate")
+
+	aval := fg.NewPipe("aval")
+	array := fg.NewHub("array", flowgraph.Array, []interface{}{1, 2, 3}).
+		ConnectResults(aval)
+	add := fg.NewHub("add", flowgraph.Add, nil).
+		SetSource(0, aval)
+	aval2 := fg.NewPipe("aval")
+	array2 := fg.NewHub("array", flowgraph.Array, []interface{}{4, 5, 6}).
+		ConnectResults(aval2)
+	// NewHub, NewPipe, and SetName suffix a taken name, fgbase does not
+	array2.Base().(*fgbase.Node).Name = "array"
+	aval2.Base().(*fgbase.Edge).SetName("aval")
+	fg.NewPipe("unused")
+	fg.Connect(array, 0, add, 0)
+
+	while = fg.NewGraphHub("while", flowgraph.While)
+	while.NewHub("sub", flowgraph.Subtract, nil)
+
+	graph := fg.NewGraphHub("graph", flowgraph.Graph)
+	graph.ConnectSources(aval)
+	graph.NewHub("sub", flowgraph.Subtract, nil)
+
+	err := fg.Validate()
+	if err == nil {
+		t.Fatalf("ERROR Validate of a bad flowgraph returned nil\n")
+	}
+	want := []string{
+		`hub "array" port 0 and hub "add" port 0: both already connected to other pipes`,
+		`hub "array": name used more than once`,
+		`hub "add": source port 1 is not connected`,
+		`hub "add": result port 0 is not connected`,
+		`hub "while": Loop not called on While GraphHub`,
+		`hub "graph": # of GraphHub sources (1) does not match # of dangling internal inputs (2)`,
+		`hub "graph": # of GraphHub results (0) does not match # of dangling internal outputs (1)`,
+		`pipe "aval": name used more than once`,
+		`pipe "aval": no downstream hub`,
+		`pipe "unused": not connected`,
+	}
+	got := strings.Split(err.Error(), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("ERROR Validate returned\n%s\nexpected\n%s\n", err, strings.Join(want, "\n"))
+	}
+	fmt.Printf("END:    TestValidate\n")
+}
+
+/*=====================================================================*/
+
+func TestTry(t *testing.T) {
+	fmt.Printf("BEGIN:  TestTry\n")
+
+	fg := flowgraph.New("TestTry")
+
+	if _, err := fg.TryNewHub("retrieve", flowgraph.Retrieve, nil); err == nil ||
+		!strings.Contains(err.Error(), "not given Retriever") {
+		t.Fatalf("ERROR TryNewHub of Retrieve without Retriever returned %v\n", err)
+	}
+	if _, err := fg.TryNewHub("while", flowgraph.While, nil); err == nil {
+		t.Fatalf("ERROR TryNewHub of While returned nil\n")
+	}
+	if _, err := fg.TryNewGraphHub("add", flowgraph.Add); err == nil {
+		t.Fatalf("ERROR TryNewGraphHub of Add returned nil\n")
+	}
+
+	array, err := fg.TryNewHub("array", flowgraph.Array, []interface{}{1, 2, 3})
+	if err != nil {
+		t.Fatalf("ERROR TryNewHub of Array returned %v\n", err)
+	}
+	add, _ := fg.TryNewHub("add", flowgraph.Add, nil)
+	add.SetSourceNames("A", "B")
+
+	if _, err = add.TrySource("C"); err == nil {
+		t.Fatalf("ERROR TrySource of missing port returned nil\n")
+	}
+	if _, err = add.TryResult(1); err == nil {
+		t.Fatalf("ERROR TryResult of missing port returned nil\n")
+	}
+	if p, err := add.TrySource("B"); err != nil || !p.Empty() {
+		t.Fatalf("ERROR TrySource of unconnected port returned %v, %v\n", p, err)
+	}
+
+	if _, err = fg.TryConnect(array, 0, add, "C"); err == nil {
+		t.Fatalf("ERROR TryConnect to missing port returned nil\n")
+	}
+	if _, err = fg.TryConnect(array, 0, flowgraph.New("other").NewHub("sink", flowgraph.Sink, nil), 0); err == nil {
+		t.Fatalf("ERROR TryConnect to hub of other flowgraph returned nil\n")
+	}
+	if _, err = fg.TryConnect(nil, 0, add, "A"); err == nil {
+		t.Fatalf("ERROR TryConnect from nil hub returned nil\n")
+	}
+	if _, err = fg.TryConnect(array, 0, nil, 0); err == nil {
+		t.Fatalf("ERROR TryConnect to nil hub returned nil\n")
+	}
+	if p, err := fg.TryConnect(array, 0, add, "A"); err != nil || p == nil {
+		t.Fatalf("ERROR TryConnect returned %v, %v\n", p, err)
+	}
+	add.SetSource("B", fg.NewPipe("").Const(1))
+	if _, err = fg.TryConnect(array, 0, add, "B"); err == nil ||
+		!strings.Contains(err.Error(), "both already connected") {
+		t.Fatalf("ERROR TryConnect of connected ports returned %v\n", err)
+	}
+
+	hello := fg.NewHub("hello", flowgraph.Retrieve, flowgraph.RetrieverFunc[string](func(h flowgraph.Hub) (string, error) {
+		return "hello", nil
+	}))
+	double := fg.NewHub("double", flowgraph.AllOf, flowgraph.Func1[int, int](func(h flowgraph.Hub, a int) (int, error) {
+		return a * 2, nil
+	}))
+	hello.SetNumResult(1)
+	double.SetNumSource(1)
+	if _, err = fg.TryConnect(hello, 0, double, 0); err == nil {
+		t.Fatalf("ERROR TryConnect of string result to int source returned nil\n")
+	}
+	fmt.Printf("END:    TestTry\n")
+}
+
+/*=====================================================================*/
+
+func TestFindHub(t *testing.T) {
+	fmt.Printf("BEGIN:  TestFindHub\n")
+
+	fg := flowgraph.New("TestFindHub")
+
+	var tbcar []flowgraph.Hub
+	for i := 0; i < 3; i++ {
+		tbcar = append(tbcar, fg.NewHub("tbcar", flowgraph.Pass, nil))
+	}
+	for i, nm := range []string{"tbcar", "tbcar_1", "tbcar_2"} {
+		if tbcar[i].Name() != nm || fg.FindHub(nm) != tbcar[i] {
+			t.Fatalf("ERROR hub %d named %q, expected %q\n", i, tbcar[i].Name(), nm)
+		}
+	}
+
+	while1 := fg.NewGraphHub("while1", flowgraph.Graph)
+	while2 := while1.NewGraphHub("while2", flowgraph.Graph)
+	sub := while2.NewHub("sub", flowgraph.Subtract, nil)
+	xval := while2.NewPipe("xval")
+	fg.NewPipe("aval")
+
+	if fg.FindHub("while1.while2.sub") != sub || while1.FindHub("while2.sub") != sub {
+		t.Fatalf("ERROR FindHub of dotted path did not find sub\n")
+	}
+	if p := fg.FindPipe("while1.while2.xval"); p == nil || !p.Same(xval) {
+		t.Fatalf("ERROR FindPipe of dotted path did not find xval\n")
+	}
+	if fg.FindHub("while1.sub") != nil || fg.FindHub("tbcar.sub") != nil {
+		t.Fatalf("ERROR FindHub found a hub not there\n")
+	}
+
+	sub.SetName("sub1")
+	if while2.FindHub("sub") != nil || fg.FindHub("while1.while2.sub1") != sub {
+		t.Fatalf("ERROR FindHub after SetName\n")
+	}
+	tbcar[2].SetName("tbcar")
+	if tbcar[2].Name() != "tbcar_2" || fg.FindHub("tbcar") != tbcar[0] {
+		t.Fatalf("ERROR SetName to a taken name gave %q\n", tbcar[2].Name())
+	}
+	bval := fg.NewPipe("bval")
+	bval.SetName("aval")
+	if bval.Name() != "aval_1" || !fg.FindPipe("aval_1").Same(bval) || fg.FindPipe("bval") != nil {
+		t.Fatalf("ERROR SetName of pipe to a taken name gave %q\n", bval.Name())
+	}
+	fg.Connect(tbcar[0], 0, tbcar[1], 0)
+	if fg.NumPipe() != 4 || fg.Pipe(2) != fg.Pipe(3) {
+		t.Fatalf("ERROR Connect changed the pipe indices, %d pipes\n", fg.NumPipe())
+	}
+
+	var paths []string
+	fg.WalkHubs(func(path string, h flowgraph.Hub) bool {
+		paths = append(paths, path)
+		return true
+	})
+	want := "tbcar tbcar_1 tbcar_2 while1 while1.while2 while1.while2.sub1"
+	if strings.Join(paths, " ") != want {
+		t.Fatalf("ERROR WalkHubs walked %v, expected %s\n", paths, want)
+	}
+
+	paths = nil
+	fg.WalkHubs(func(path string, h flowgraph.Hub) bool {
+		paths = append(paths, path)
+		return h.HubCode() != flowgraph.Graph
+	})
+	if len(paths) != 4 {
+		t.Fatalf("ERROR WalkHubs did not stop, walked %v\n", paths)
+	}
+
+	paths = nil
+	fg.WalkPipes(func(path string, p flowgraph.Pipe) bool {
+		paths = append(paths, path)
+		return true
+	})
+	want = "aval aval_1  while1.while2.xval" // the pipe of Connect is unnamed
+	if strings.Join(paths, " ") != want {
+		t.Fatalf("ERROR WalkPipes walked %v, expected %s\n", paths, want)
+	}
+	fmt.Printf("END:    TestFindHub\n")
+}
+
+/*=====================================================================*/
+
+/* TestOptions Flowgraph HDL *
+
+array()(p)
+sink(p)()
+
+*/
+
+func TestOptions(t *testing.T) {
+	fmt.Printf("BEGIN:  TestOptions\n")
+	oldTraceLevel := fgbase.TraceLevel
+	oldRunTime := fgbase.RunTime
+	oldChannelSize := fgbase.ChannelSize
+	fgbase.ChannelSize = 4 // room for the pipe capacity of o
+
+	tests := []struct {
+		name  string
+		level fgbase.TraceLevelType
+		dot   bool
+		want  string // in the trace output, none if empty
+	}{
+		{"quiet", fgbase.Q, false, ""},
+		{"traced", fgbase.VV, false, "sink:  [0] -> []"},
+		{"dot", fgbase.Q, true, `"array" -> "sink"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b strings.Builder
+			o := flowgraph.DefaultOptions()
+			o.TraceLevel = test.level
+			o.TraceWriter = &b
+			o.ChannelSize = 4
+			o.RunTime = -1
+			o.DotOutput = test.dot
+			fg := flowgraph.NewWithOptions("TestOptions_"+test.name, o)
+
+			arr := []interface{}{0, 1, 2, 3}
+			s := &fgbase.SinkStats{}
+			p := fg.NewPipe("p")
+			fg.NewHub("array", flowgraph.Array, arr).
+				ConnectResults(p)
+			fg.NewHub("sink", flowgraph.Sink, s).
+				ConnectSources(p)
+
+			if c := p.Base().(*fgbase.Edge).RdyCnt; c != o.ChannelSize {
+				t.Fatalf("ERROR pipe capacity %d, expected %d\n", c, o.ChannelSize)
+			}
+			if fg.Options().RunTime != o.RunTime {
+				t.Fatalf("ERROR Options().RunTime %v, expected %v\n", fg.Options().RunTime, o.RunTime)
+			}
+
+			fg.Run()
+
+			if !test.dot && s.Cnt != len(arr) {
+				t.Fatalf("ERROR SinkStats.Cnt %d != len(arr) (%d)\n", s.Cnt, len(arr))
+			}
+			if test.want == "" && b.Len() != 0 || !strings.Contains(b.String(), test.want) {
+				t.Fatalf("ERROR trace level %v wrote %q\n", test.level, b.String())
+			}
+		})
+	}
+
+	if fgbase.TraceLevel != oldTraceLevel || fgbase.RunTime != oldRunTime {
+		t.Fatalf("ERROR fgbase globals changed by Run\n")
+	}
+	fgbase.ChannelSize = oldChannelSize
+	fmt.Printf("END:    TestOptions\n")
+}
+
+/*=====================================================================*/
+
+// array(arr) -> double(slow) -> sink, traced at VV
+// array(arr) -> sink, quiet and with a zero ChannelSize, run at the same time
+
+/* TestOptionsConcurrent Flowgraph HDL *
+
+array()(a)
+double(a)(x)
+sink(x)()
+
+array()(p)
+sink(p)()
+
+*/
+
+func TestOptionsConcurrent(t *testing.T) {
+	fmt.Printf("BEGIN:  TestOptionsConcurrent\n")
+	oldChannelSize := fgbase.ChannelSize
+	fgbase.ChannelSize = 4 // room for the pipe capacity of o
+
+	var traced, quiet strings.Builder
+	o := flowgraph.DefaultOptions()
+	o.TraceLevel = fgbase.VV
+	o.TraceWriter = &traced
+	o.ChannelSize = 3
+	o.RunTime = -1
+	slowfg := flowgraph.NewWithOptions("TestOptionsConcurrent_slow", o)
+	slow := func(h flowgraph.Hub, a int) (int, error) {
+		time.Sleep(10 * time.Millisecond)
+		return 2 * a, nil
+	}
+	slowArr := make([]interface{}, 20)
+	for i := range slowArr {
+		slowArr[i] = i
+	}
+	a, x := slowfg.NewPipe("a"), slowfg.NewPipe("x")
+	slowfg.NewHub("array", flowgraph.Array, slowArr).
+		ConnectResults(a)
+	slowfg.NewHub("double", flowgraph.AllOf, flowgraph.Func1[int, int](slow)).
+		ConnectSources(a).ConnectResults(x)
+	slowSink := &fgbase.SinkStats{}
+	slowfg.NewHub("sink", flowgraph.Sink, slowSink).
+		ConnectSources(x)
+
+	quickfg := flowgraph.NewWithOptions("TestOptionsConcurrent_quick", flowgraph.Options{RunTime: -1, TraceWriter: &quiet})
+	quickArr := make([]interface{}, 100)
+	for i := range quickArr {
+		quickArr[i] = i
+	}
+	p := quickfg.NewPipe("p")
+	quickfg.NewHub("array", flowgraph.Array, quickArr).
+		ConnectResults(p)
+	quickSink := &fgbase.SinkStats{}
+	quickfg.NewHub("sink", flowgraph.Sink, quickSink).
+		ConnectSources(p)
+
+	if c := a.Base().(*fgbase.Edge).RdyCnt; c != 3 {
+		t.Fatalf("ERROR slow pipe capacity %d, expected 3\n", c)
+	}
+	if c := p.Base().(*fgbase.Edge).RdyCnt; c != 4 {
+		t.Fatalf("ERROR quick pipe capacity %d, expected the default 4\n", c)
+	}
+
+	slowDone := make(chan time.Time)
+	go func() {
+		slowfg.Run()
+		slowDone <- time.Now()
+	}()
+	quickfg.Run()
+	quickEnd := time.Now()
+	slowEnd := <-slowDone
+
+	if !quickEnd.Before(slowEnd) {
+		t.Fatalf("ERROR quick run ended %v after the slow run\n", quickEnd.Sub(slowEnd))
+	}
+	if slowSink.Cnt != len(slowArr) || quickSink.Cnt != len(quickArr) {
+		t.Fatalf("ERROR sinks counted %d and %d, expected %d and %d\n", slowSink.Cnt, quickSink.Cnt, len(slowArr), len(quickArr))
+	}
+	if !strings.Contains(traced.String(), "double:  [19] -> [38]") || quiet.Len() != 0 {
+		t.Fatalf("ERROR traces of the runs were %q and %q\n", traced.String(), quiet.String())
+	}
+	fgbase.ChannelSize = oldChannelSize
+	fmt.Printf("END:    TestOptionsConcurrent\n")
+}
+
+/*=====================================================================*/
+
+/* TestAnalyze Flowgraph HDL *
+
+add(a, 1)(b)
+pass(b)(a)
+
+*/
+
+func TestAnalyze(t *testing.T) {
+	fmt.Printf("BEGIN:  TestAnalyze\n")
+	tests := []struct {
+		name  string
+		build func(fg flowgraph.Flowgraph)
+		want  []string
+	}{
+		{"deadlock", func(fg flowgraph.Flowgraph) {
+			a, b := fg.NewPipe("a"), fg.NewPipe("b")
+			fg.NewHub("add", flowgraph.Add, nil).
+				ConnectSources(a, fg.NewPipe("one").Const(1)).ConnectResults(b)
+			fg.NewHub("pass", flowgraph.Pass, nil).
+				ConnectSources(b).ConnectResults(a)
+		}, []string{`deadlock (no initial values): hub "add" -> pipe "b" -> hub "pass" -> pipe "a" -> hub "add"`}},
+		{"initialized", func(fg flowgraph.Flowgraph) {
+			a, b := fg.NewPipe("a").Init(0), fg.NewPipe("b")
+			fg.NewHub("add", flowgraph.Add, nil).
+				ConnectSources(a, fg.NewPipe("one").Const(1)).ConnectResults(b)
+			fg.NewHub("pass", flowgraph.Pass, nil).
+				ConnectSources(b).ConnectResults(a)
+		}, nil},
+		{"gridlock", func(fg flowgraph.Flowgraph) {
+			a := fg.NewPipe("a").Init(0).SetCapacity(1)
+			fg.NewHub("add", flowgraph.Add, nil).
+				ConnectSources(a, fg.NewPipe("one").Const(1)).ConnectResults(a)
+		}, []string{`gridlock (1 values, capacity 1): hub "add" -> pipe "a" -> hub "add"`}},
+		{"capacity", func(fg flowgraph.Flowgraph) {
+			a := fg.NewPipe("a").Init(0).SetCapacity(2)
+			fg.NewHub("add", flowgraph.Add, nil).
+				ConnectSources(a, fg.NewPipe("one").Const(1)).ConnectResults(a)
+		}, nil},
+		{"OneOf", func(fg flowgraph.Flowgraph) {
+			a, b := fg.NewPipe("a"), fg.NewPipe("b")
+			fg.NewHub("oneof", flowgraph.OneOf, &pass{}).
+				ConnectSources(a, fg.NewPipe("one").Const(1)).ConnectResults(b)
+			fg.NewHub("pass", flowgraph.Pass, nil).
+				ConnectSources(b).ConnectResults(a)
+		}, nil},
+		{"GraphHub", func(fg flowgraph.Flowgraph) {
+			x := fg.NewPipe("x")
+			fg.NewHub("ten", flowgraph.Constant, 10).ConnectResults(x)
+			while := fg.NewGraphHub("while", flowgraph.While)
+			while.ConnectSources(x).ConnectResults(fg.NewPipe("").Sink())
+			while.NewHub("sub", flowgraph.Subtract, nil).
+				SetNumSource(2).SetNumResult(1).
+				SetSource(1, while.NewPipe("one").Const(1))
+			while.Loop()
+
+			g := fg.NewGraphHub("graph", flowgraph.Graph)
+			a, b := g.NewPipe("a"), g.NewPipe("b")
+			g.NewHub("pass1", flowgraph.Pass, nil).ConnectSources(a).ConnectResults(b)
+			g.NewHub("pass2", flowgraph.Pass, nil).ConnectSources(b).ConnectResults(a)
+		}, []string{`deadlock (no initial values): hub "graph.pass1" -> pipe "graph.b" -> hub "graph.pass2" -> pipe "graph.a" -> hub "graph.pass1"`}},
+	}
+
+	oldChannelSize := fgbase.ChannelSize
+	fgbase.ChannelSize = 2 // room for the capacity case
+	for _, test := range tests {
+		fg := flowgraph.New("TestAnalyze")
+		test.build(fg)
+		cycles := fg.Analyze()
+		var got []string
+		for _, c := range cycles {
+			got = append(got, c.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Fatalf("ERROR Analyze %s found\n%s\nexpected\n%s\n", test.name, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+	fgbase.ChannelSize = oldChannelSize
+	fmt.Printf("END:    TestAnalyze\n")
+}
+
+/*=====================================================================*/
+
+/* TestStall Flowgraph HDL *
+
+add(a, 1)(b)
+pass(b)(a)
+
+*/
+
+func TestStall(t *testing.T) {
+	fmt.Printf("BEGIN:  TestStall\n")
+	build := func(o flowgraph.Options) flowgraph.Flowgraph {
+		fg := flowgraph.NewWithOptions("TestStall", o)
+		a, b := fg.NewPipe("a"), fg.NewPipe("b")
+		fg.NewHub("add", flowgraph.Add, nil).
+			ConnectSources(a, fg.NewPipe("one").Const(1)).ConnectResults(b)
+		fg.NewHub("pass", flowgraph.Pass, nil).
+			ConnectSources(b).ConnectResults(a)
+		return fg
+	}
+
+	o := flowgraph.DefaultOptions()
+	o.RunTime = -1
+	o.StallTimeout = time.Second / 20
+	o.FailOnStall = true
+	err := build(o).Run()
+
+	var serr *flowgraph.StallError
+	if !errors.As(err, &serr) {
+		t.Fatalf("ERROR Stall Run returned %v, expected a StallError\n", err)
+	}
+	s := serr.Stall
+	if len(s.Hubs) != 2 || s.Hubs[0].Name != "add" || len(s.Hubs[0].Empty) != 1 || s.Hubs[0].Empty[0] != `"a"` {
+		t.Fatalf("ERROR Stall hubs %+v\n", s.Hubs)
+	}
+	if strings.Join(s.Cycle, " ") != "add pass" {
+		t.Fatalf("ERROR Stall cycle %v, expected [add pass]\n", s.Cycle)
+	}
+	if !strings.Contains(s.Dot(), `"add" -> "pass" [label="b", style=dashed, color=red, penwidth=2];`) {
+		t.Fatalf("ERROR Stall dot\n%s\n", s.Dot())
+	}
+
+	// without FailOnStall the stall is written to the trace output
+	var b strings.Builder
+	o.RunTime = time.Second / 5
+	o.FailOnStall = false
+	o.TraceWriter = &b
+	if err := build(o).Run(); err != nil {
+		t.Fatalf("ERROR Stall Run returned %v\n", err)
+	}
+	if !strings.Contains(b.String(), `waiting in a cycle: "add" "pass" "add"`) {
+		t.Fatalf("ERROR Stall trace\n%s\n", b.String())
+	}
+	fmt.Printf("END:    TestStall\n")
+}
+
+/*=====================================================================*/
+
+/* TestStats Flowgraph HDL *
+
+marr()(mval)
+narr()(nval)
+while(mval, nval)(tcond, gcd) {
+        pass(mval)(gcd)
+        mod(nval, mval)(tcond)
+}
+double(gcd)(x)
+sink(x)()
+sink2(tcond)()
+
+*/
+
+func TestStats(t *testing.T) {
+	fmt.Printf("BEGIN:  TestStats\n")
+	o := flowgraph.DefaultOptions()
+	o.RunTime = time.Second / 2
+	o.TraceLevel = fgbase.Q
+	fg := flowgraph.NewWithOptions("TestStats", o)
+
+	gcdLoop(fg, &cycle{vals: gcdMs}, &cycle{vals: gcdNs}, nil)
+	x := fg.NewPipe("x")
+	fg.NewHub("double", flowgraph.AllOf, &transformer{}).
+		ConnectSources(fg.FindPipe("gcd")).ConnectResults(x)
+	fg.NewHub("sink", flowgraph.Sink, nil).
+		ConnectSources(x)
+
+	hub := func(s flowgraph.Stats, name string) flowgraph.HubStats {
+		for _, h := range s.Hubs {
+			if h.Name == name {
+				return h
+			}
+		}
+		t.Fatalf("ERROR Stats has no hub %q\n", name)
+		return flowgraph.HubStats{}
+	}
+	pipe := func(s flowgraph.Stats, name string) flowgraph.PipeStats {
+		for _, p := range s.Pipes {
+			if p.Name == name {
+				return p
+			}
+		}
+		t.Fatalf("ERROR Stats has no pipe %q\n", name)
+		return flowgraph.PipeStats{}
+	}
+
+	if h := hub(fg.Stats(), "double"); h.Fires != 0 {
+		t.Fatalf("ERROR Stats before Run has %d fires\n", h.Fires)
+	}
+
+	fg.Run()
+	s := fg.Stats()
+
+	h := hub(s, "double")
+	var calls int64
+	for _, c := range h.UserHist {
+		calls += c
+	}
+	if h.Fires == 0 || calls == 0 || calls > h.Fires || h.UserMin > h.UserMax || h.UserTime < h.UserMax {
+		t.Fatalf("ERROR Stats for double %+v\n", h)
+	}
+	if h := hub(s, "while.mod"); h.Fires == 0 || h.Code != flowgraph.Modulo {
+		t.Fatalf("ERROR Stats for while.mod %+v\n", h)
+	}
+	if p := pipe(s, "x"); p.Tokens == 0 || p.Occupancy < 0 || p.Occupancy > 2 {
+		t.Fatalf("ERROR Stats for pipe x %+v\n", p)
+	}
+	pipe(s, "while.whileWait[0]")
+	fmt.Printf("END:    TestStats\n")
+}
+
+/*=====================================================================*/
+
+/* TestTraceEvents Flowgraph HDL *
+
+array()(a)
+double(a)(x)
+sink(x)()
+
+*/
+
+func TestTraceEvents(t *testing.T) {
+	fmt.Printf("BEGIN:  TestTraceEvents\n")
+	var b strings.Builder
+	o := flowgraph.DefaultOptions()
+	o.RunTime = -1
+	o.TraceLevel = fgbase.Q
+	o.TraceEvents = &b
+	fg := flowgraph.NewWithOptions("TestTraceEvents", o)
+
+	double := flowgraph.Func1[int, int](func(h flowgraph.Hub, a int) (int, error) {
+		return a * 2, nil
+	})
+	a, x := fg.NewPipe("a"), fg.NewPipe("x")
+	fg.NewHub("array", flowgraph.Array, []interface{}{1, 2, 3}).
+		ConnectResults(a)
+	fg.NewHub("double", flowgraph.AllOf, double).
+		ConnectSources(a).ConnectResults(x)
+	fg.NewHub("sink", flowgraph.Sink, nil).
+		ConnectSources(x)
+
+	if err := fg.Run(); err != nil {
+		t.Fatalf("ERROR TraceEvents Run returned %v\n", err)
+	}
+
+	var trace struct {
+		TraceEvents []struct {
+			Name string
+			Cat  string
+			Ph   string
+			Ts   float64
+			Tid  int64
+			ID   int64
+			Args map[string]map[string]int64
+		}
+	}
+	if err := json.Unmarshal([]byte(b.String()), &trace); err != nil {
+		t.Fatalf("ERROR TraceEvents wrote bad JSON:  %v\n%s\n", err, b.String())
+	}
+
+	begins, ends := 0, 0
+	starts := make(map[int64]bool)
+	for _, e := range trace.TraceEvents {
+		switch {
+		case e.Name == "double" && e.Ph == "B":
+			if e.Cat != "AllOf" || e.Tid == 0 {
+				t.Fatalf("ERROR TraceEvents begin event %+v\n", e)
+			}
+			if tok, ok := e.Args["sources"]["a"]; ok && tok != e.Args["results"]["x"] {
+				t.Fatalf("ERROR TraceEvents token %d of a doubled to token %d of x\n", tok, e.Args["results"]["x"])
+			}
+			begins++
+		case e.Name == "double" && e.Ph == "E":
+			ends++
+		case e.Name == "x" && e.Ph == "s":
+			starts[e.ID] = true
+		case e.Name == "x" && e.Ph == "f":
+			if !starts[e.ID] {
+				t.Fatalf("ERROR TraceEvents flow end %+v before its start\n", e)
+			}
+		}
+	}
+	if begins < 3 || begins != ends || len(starts) < 3 {
+		t.Fatalf("ERROR TraceEvents %d begins, %d ends, %d flows on x\n", begins, ends, len(starts))
+	}
+	fmt.Printf("END:    TestTraceEvents\n")
+}
+
+/*=====================================================================*/
+
+/* TestVCD Flowgraph HDL *
+
+array()(a)
+graph(a)(x) {
+        double(a)(d)
+        pass(d)(x)
+}
+sink(x)()
+
+*/
+
+func TestVCD(t *testing.T) {
+	fmt.Printf("BEGIN:  TestVCD\n")
+	var b strings.Builder
+	o := flowgraph.DefaultOptions()
+	o.RunTime = -1
+	o.TraceLevel = fgbase.Q
+	o.VCD = &b
+	o.VCDFirings = true
+	fg := flowgraph.NewWithOptions("TestVCD", o)
+
+	double := flowgraph.Func1[int, int](func(h flowgraph.Hub, a int) (int, error) {
+		return a * 2, nil
+	})
+	a, x := fg.NewPipe("a"), fg.NewPipe("x")
+	fg.NewHub("array", flowgraph.Array, []interface{}{1, 2, 3}).
+		ConnectResults(a)
+	graph := fg.NewGraphHub("graph", flowgraph.Graph)
+	graph.ConnectSources(a).ConnectResults(x)
+	in, d, out := graph.NewPipe("in"), graph.NewPipe("d"), graph.NewPipe("out")
+	graph.NewHub("double", flowgraph.AllOf, double).
+		ConnectSources(in).ConnectResults(d)
+	graph.NewHub("pass", flowgraph.Pass, nil).
+		ConnectSources(d).ConnectResults(out)
+	graph.ExposeSource(in)
+	graph.ExposeResult(out)
+	fg.NewHub("sink", flowgraph.Sink, nil).
+		ConnectSources(x)
+
+	if err := fg.Run(); err != nil {
+		t.Fatalf("ERROR VCD Run returned %v\n", err)
+	}
+
+	vcd := b.String()
+	ids := make(map[string]string)
+	scope := []string{}
+	for _, line := range strings.Split(vcd, "\n") {
+		f := strings.Fields(line)
+		switch {
+		case len(f) == 4 && f[0] == "$scope":
+			scope = append(scope, f[2])
+		case len(f) == 2 && f[0] == "$upscope" && len(scope) > 0:
+			scope = scope[:len(scope)-1]
+		case len(f) == 6 && f[0] == "$var":
+			ids[strings.Join(append(scope, f[4]), ".")] = f[3]
+		}
+	}
+	for _, name := range []string{"TestVCD.a", "TestVCD.a_valid", "TestVCD.a_ready", "TestVCD.graph.d", "TestVCD.graph.d_valid"} {
+		if ids[name] == "" {
+			t.Fatalf("ERROR VCD has no signal %s\n%s\n", name, vcd)
+		}
+	}
+	if len(scope) != 0 || !strings.Contains(vcd, "$enddefinitions $end\n#0\n$dumpvars\n") {
+		t.Fatalf("ERROR VCD header\n%s\n", vcd)
+	}
+
+	// every value doubled on d, and valid set with each
+	for _, v := range []string{"b10 ", "b100 ", "b110 "} {
+		if !strings.Contains(vcd, v+ids["TestVCD.graph.d"]+"\n") {
+			t.Fatalf("ERROR VCD has no change of d to %s\n%s\n", v, vcd)
+		}
+	}
+	if !strings.Contains(vcd, "\n1"+ids["TestVCD.a_valid"]+"\n") {
+		t.Fatalf("ERROR VCD has no valid on a\n%s\n", vcd)
+	}
+	if !strings.Contains(vcd, "\n#3\n") {
+		t.Fatalf("ERROR VCD is not timed by firings\n%s\n", vcd)
+	}
+	fmt.Printf("END:    TestVCD\n")
+}
+
+/*=====================================================================*/
+
+/* TestClocked Flowgraph HDL *
+
+array()(a)
+pass1(a)(b)
+pass2(b)(c)
+sink(c)()
+
+marr()(mval)
+narr()(nval)
+while(mval, nval)(tcond, gcd) {
+        pass(mval)(gcd)
+        mod(nval, mval)(tcond)
+}
+sink(gcd)()
+sink2(tcond)()
+
+*/
+
+func TestClocked(t *testing.T) {
+	fmt.Printf("BEGIN:  TestClocked\n")
+	options := func() flowgraph.Options {
+		o := flowgraph.DefaultOptions()
+		o.RunTime = -1
+		o.TraceLevel = fgbase.Q
+		o.Executor = flowgraph.Clocked
+		return o
+	}
+
+	// a value and then EOS leave the array every cycle if the pipes are
+	// two deep, every other cycle if one deep, and take three more cycles
+	// to reach the sink
+	for _, test := range []struct {
+		capacity int
+		cycles   int64
+	}{{2, 8}, {1, 12}} {
+		o := options()
+		o.ChannelSize = test.capacity
+		fg := flowgraph.NewWithOptions("TestClocked", o)
+		a, b, c := fg.NewPipe("a"), fg.NewPipe("b"), fg.NewPipe("c")
+		fg.NewHub("array", flowgraph.Array, []interface{}{1, 2, 3, 4}).
+			ConnectResults(a)
+		fg.NewHub("pass1", flowgraph.Pass, nil).
+			ConnectSources(a).ConnectResults(b)
+		fg.NewHub("pass2", flowgraph.Pass, nil).
+			ConnectSources(b).ConnectResults(c)
+		s := &collect{}
+		fg.NewHub("sink", flowgraph.Sink, s).
+			ConnectSources(c)
+
+		if err := fg.Run(); err != nil {
+			t.Fatalf("ERROR Clocked Run returned %v\n", err)
+		}
+		if fmt.Sprint(s.vals) != "[1 2 3 4]" {
+			t.Fatalf("ERROR Clocked pipeline of capacity %d sank %v\n", test.capacity, s.vals)
+		}
+		if c := fg.Stats().Cycles; c != test.cycles {
+			t.Fatalf("ERROR Clocked pipeline of capacity %d ran %d cycles, expected %d\n", test.capacity, c, test.cycles)
+		}
+	}
+
+	// a while loop runs the same every time
+	gcd := func() ([]interface{}, int64) {
+		o := options()
+		o.MaxCycles = 200
+		fg := flowgraph.NewWithOptions("TestClocked", o)
+		c := &collect{}
+		gcdLoop(fg, &cycle{vals: gcdMs}, &cycle{vals: gcdNs}, c)
+
+		if err := fg.Run(); err != nil {
+			t.Fatalf("ERROR Clocked Run returned %v\n", err)
+		}
+		return c.vals, fg.Stats().Cycles
+	}
+	vals, cycles := gcd()
+	if len(vals) == 0 || cycles != 200 {
+		t.Fatalf("ERROR Clocked while loop found %v in %d cycles\n", vals, cycles)
+	}
+	for i, v := range vals {
+		if v != gcds[i%len(gcds)] {
+			t.Fatalf("ERROR Clocked while loop found %v\n", vals)
+		}
+	}
+	if vals2, cycles2 := gcd(); fmt.Sprint(vals2) != fmt.Sprint(vals) || cycles2 != cycles {
+		t.Fatalf("ERROR Clocked while loop found %v in %d cycles, then %v in %d\n", vals, cycles, vals2, cycles2)
+	}
+	fmt.Printf("END:    TestClocked\n")
+}
+
+/*=====================================================================*/
+
+/* TestSequential Flowgraph HDL *
+
+array()(a)
+p1(a)(b)
+p2(b)(c)
+sink(c)()
+
+*/
+
+func TestSequential(t *testing.T) {
+	fmt.Printf("BEGIN:  TestSequential\n")
+	// run returns the order the two AllOf hubs of a pipeline fired in
+	run := func(o flowgraph.Options) string {
+		o.RunTime = -1
+		o.TraceLevel = fgbase.Q
+		o.Executor = flowgraph.Sequential
+		fg := flowgraph.NewWithOptions("TestSequential", o)
+
+		var fired []string
+		pass := func(h flowgraph.Hub, a int) (int, error) {
+			fired = append(fired, h.Name())
+			return a, nil
+		}
+		a, b, c := fg.NewPipe("a"), fg.NewPipe("b"), fg.NewPipe("c")
+		fg.NewHub("array", flowgraph.Array, []interface{}{1, 2, 3, 4}).
+			ConnectResults(a)
+		fg.NewHub("p1", flowgraph.AllOf, flowgraph.Func1[int, int](pass)).
+			ConnectSources(a).ConnectResults(b)
+		fg.NewHub("p2", flowgraph.AllOf, flowgraph.Func1[int, int](pass)).
+			ConnectSources(b).ConnectResults(c)
+		s := &collect{}
+		fg.NewHub("sink", flowgraph.Sink, s).
+			ConnectSources(c)
+
+		if err := fg.Run(); err != nil {
+			t.Fatalf("ERROR Sequential Run returned %v\n", err)
+		}
+		if fmt.Sprint(s.vals) != "[1 2 3 4]" {
+			t.Fatalf("ERROR Sequential %v schedule sank %v\n", o.Schedule, s.vals)
+		}
+		return strings.Join(fired, " ")
+	}
+
+	o := flowgraph.DefaultOptions()
+	o.Schedule = flowgraph.RoundRobin
+	if f := run(o); f != "p1 p2 p1 p2 p1 p2 p1 p2" {
+		t.Fatalf("ERROR Sequential RoundRobin fired %s\n", f)
+	}
+
+	// upstream first fills the pipes before draining them
+	o.Schedule = flowgraph.Priority
+	o.ChannelSize = 4
+	o.HubPriority = func(h flowgraph.Hub) int {
+		return map[string]int{"array": 3, "p1": 2, "p2": 1}[h.Name()]
+	}
+	if f := run(o); f != "p1 p1 p1 p1 p2 p2 p2 p2" {
+		t.Fatalf("ERROR Sequential Priority fired %s\n", f)
+	}
+
+	o = flowgraph.DefaultOptions()
+	o.Schedule = flowgraph.Random
+	o.ChannelSize = 4
+	seen := make(map[string]bool)
+	for seed := int64(1); seed <= 5; seed++ {
+		o.Seed = seed
+		f := run(o)
+		if g := run(o); g != f {
+			t.Fatalf("ERROR Sequential Random seed %d fired %s, then %s\n", seed, f, g)
+		}
+		seen[f] = true
+	}
+	if len(seen) < 2 {
+		t.Fatalf("ERROR Sequential Random fired the same for every seed\n")
+	}
+	fmt.Printf("END:    TestSequential\n")
+}
+
+/*=====================================================================*/
+
+/* TestRunContextMaxCycles Flowgraph HDL *
+
+one()(a)
+sink(a)()
+
+*/
+
+func TestRunContextMaxCycles(t *testing.T) {
+	fmt.Printf("BEGIN:  TestRunContextMaxCycles\n")
+	for _, e := range []flowgraph.Executor{flowgraph.Clocked, flowgraph.Sequential} {
+		o := flowgraph.DefaultOptions()
+		o.RunTime = -1
+		o.TraceLevel = fgbase.Q
+		o.Executor = e
+		o.MaxCycles = 5
+		fg := flowgraph.NewWithOptions("TestRunContextMaxCycles", o)
+
+		a := fg.NewPipe("a")
+		fg.NewHub("one", flowgraph.Constant, 1).
+			ConnectResults(a)
+		fg.NewHub("sink", flowgraph.Sink, nil).
+			ConnectSources(a)
+
+		ran := make(chan error)
+		go func() { ran <- fg.RunContext(context.Background()) }()
+		select {
+		case err := <-ran:
+			if err != nil {
+				t.Fatalf("ERROR RunContext with %v executor returned %v\n", e, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ERROR RunContext with %v executor did not return after MaxCycles\n", e)
+		}
+		if c := fg.Stats().Cycles; c != o.MaxCycles {
+			t.Fatalf("ERROR RunContext with %v executor ran %d cycles, expected %d\n", e, c, o.MaxCycles)
+		}
+	}
+	fmt.Printf("END:    TestRunContextMaxCycles\n")
+}
+
+/*=====================================================================*/
+
+// chain returns a flowgraph of l hubs in a chain between an array and a sink
+func chain(o flowgraph.Options, l int, arr []interface{}) (flowgraph.Flowgraph, *collect) {
+	fg := flowgraph.NewWithOptions("chain", o)
+	x := fg.NewPipe("")
+	fg.NewHub("array", flowgraph.Array, arr).
+		ConnectResults(x)
+	for i := 0; i < l; i++ {
+		y := fg.NewPipe("")
+		fg.NewHub(fmt.Sprintf("t%04d", i), flowgraph.AllOf, &pass{}).
+			SetSourceNames("A").SetResultNames("X").
+			ConnectSources(x).ConnectResults(y)
+		x = y
+	}
+	c := &collect{}
+	fg.NewHub("sink", flowgraph.Sink, c).
+		ConnectSources(x)
+	return fg, c
+}
+
+// fanOut returns a flowgraph of an array feeding w sinks
+func fanOut(o flowgraph.Options, w int, arr []interface{}) flowgraph.Flowgraph {
+	fg := flowgraph.NewWithOptions("fanOut", o)
+	x := fg.NewPipe("x")
+	fg.NewHub("array", flowgraph.Array, arr).
+		ConnectResults(x)
+	for i := 0; i < w; i++ {
+		fg.NewHub(fmt.Sprintf("sink%04d", i), flowgraph.Sink, nil).
+			ConnectSources(x)
+	}
+	return fg
+}
+
+/* TestWorkerPool Flowgraph HDL *
+
+array()(x)
+t0000(.A(x))(.X(y))
+...
+sink(z)()
+
+marr()(mval)
+narr()(nval)
+while(mval, nval)(tcond, gcd) {
+        pass(mval)(gcd)
+        mod(nval, mval)(tcond)
+}
+sink(gcd)()
+sink2(tcond)()
+
+*/
+
+func TestWorkerPool(t *testing.T) {
+	fmt.Printf("BEGIN:  TestWorkerPool\n")
+	o := flowgraph.DefaultOptions()
+	o.RunTime = -1
+	o.TraceLevel = fgbase.Q
+	o.Executor = flowgraph.WorkerPool
+
+	arr := make([]interface{}, 100)
+	for i := range arr {
+		arr[i] = i
+	}
+	for _, workers := range []int{1, 4, 0} {
+		o.Workers = workers
+		fg, c := chain(o, 64, arr)
+		if err := fg.Run(); err != nil {
+			t.Fatalf("ERROR WorkerPool Run returned %v\n", err)
+		}
+		if fmt.Sprint(c.vals) != fmt.Sprint(arr) {
+			t.Fatalf("ERROR WorkerPool of %d workers sank %v\n", workers, c.vals)
+		}
+		if err := fanOut(o, 64, arr).Run(); err != nil {
+			t.Fatalf("ERROR WorkerPool Run returned %v\n", err)
+		}
+	}
+
+	// a while loop is drained once cancelled
+	fg := flowgraph.NewWithOptions("TestWorkerPool", o)
+	marr, narr, c := &cycle{vals: gcdMs}, &cycle{vals: gcdNs}, &collect{}
+	gcdLoop(fg, marr, narr, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/4)
+	defer cancel()
+	if err := fg.RunContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ERROR WorkerPool RunContext returned %v\n", err)
+	}
+	want := make(map[interface{}]int)
+	for i := 0; i < min(marr.i, narr.i); i++ {
+		want[gcds[i%len(gcds)]]++
+	}
+	got := make(map[interface{}]int)
+	for _, v := range c.vals {
+		got[v]++
+	}
+	if len(c.vals) == 0 || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ERROR WorkerPool while loop sank %v, expected %v\n", got, want)
+	}
+	fmt.Printf("END:    TestWorkerPool\n")
+}
+
+func benchmarkExecutors(b *testing.B, build func(o flowgraph.Options) flowgraph.Flowgraph) {
+	for _, e := range []flowgraph.Executor{flowgraph.Goroutines, flowgraph.WorkerPool} {
+		b.Run(e.String(), func(b *testing.B) {
+			o := flowgraph.DefaultOptions()
+			o.RunTime = -1
+			o.TraceLevel = fgbase.Q
+			o.Executor = e
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				fg := build(o)
+				b.StartTimer()
+				fg.Run()
+			}
+		})
+	}
+}
+
+func BenchmarkChain(b *testing.B) {
+	arr := make([]interface{}, 100)
+	for i := range arr {
+		arr[i] = i
+	}
+	benchmarkExecutors(b, func(o flowgraph.Options) flowgraph.Flowgraph {
+		fg, _ := chain(o, 1024, arr)
+		return fg
+	})
+}
+
+func BenchmarkFanOut(b *testing.B) {
+	arr := make([]interface{}, 100)
+	for i := range arr {
+		arr[i] = i
+	}
+	benchmarkExecutors(b, func(o flowgraph.Options) flowgraph.Flowgraph {
+		return fanOut(o, 1024, arr)
+	})
+}
+
+/*=====================================================================*/
+
+/* TestParallelism Flowgraph HDL *
+
+array()(a)
+double(a)(x)
+sink(x)()
+
+*/
+
+func TestParallelism(t *testing.T) {
+	fmt.Printf("BEGIN:  TestParallelism\n")
+	arr := make([]interface{}, 32)
+	want := make([]interface{}, len(arr))
+	for i := range arr {
+		arr[i], want[i] = i, 2*i
+	}
+
+	for _, e := range []flowgraph.Executor{flowgraph.Goroutines, flowgraph.WorkerPool, flowgraph.Sequential} {
+		o := flowgraph.DefaultOptions()
+		o.RunTime = -1
+		o.TraceLevel = fgbase.Q
+		o.Executor = e
+		o.Workers = 8
+		fg := flowgraph.NewWithOptions("TestParallelism", o)
+
+		var running, most int64
+		slow := func(h flowgraph.Hub, a int) (int, error) {
+			r := atomic.AddInt64(&running, 1)
+			defer atomic.AddInt64(&running, -1)
+			for m := atomic.LoadInt64(&most); r > m && !atomic.CompareAndSwapInt64(&most, m, r); m = atomic.LoadInt64(&most) {
+			}
+			time.Sleep(time.Duration(3-a%3) * time.Millisecond) // later values finish first
+			return 2 * a, nil
+		}
+
+		a, x := fg.NewPipe("a"), fg.NewPipe("x")
+		fg.NewHub("array", flowgraph.Array, arr).
+			ConnectResults(a)
+		double := fg.NewHub("double", flowgraph.AllOf, flowgraph.Func1[int, int](slow)).
+			SetParallelism(4).
+			ConnectSources(a).ConnectResults(x)
+		c := &collect{}
+		fg.NewHub("sink", flowgraph.Sink, c).
+			ConnectSources(x)
+
+		if double.Parallelism() != 4 {
+			t.Fatalf("ERROR Parallelism %d, expected 4\n", double.Parallelism())
+		}
+		if err := fg.Run(); err != nil {
+			t.Fatalf("ERROR Parallelism Run returned %v\n", err)
+		}
+		if fmt.Sprint(c.vals) != fmt.Sprint(want) {
+			t.Fatalf("ERROR Parallelism with %v executor sank %v\n", e, c.vals)
+		}
+		if double.NumSource() != 1 || !double.Source(0).Same(a) || double.NumResult() != 1 || !double.Result(0).Same(x) ||
+			a.NumDownstream() != 1 || a.Downstream(0) != double || x.NumUpstream() != 1 || x.Upstream(0) != double {
+			t.Fatalf("ERROR Parallelism with %v executor left double rewired\n", e)
+		}
+		if err := fg.Validate(); err != nil {
+			t.Fatalf("ERROR Parallelism with %v executor Validate after Run returned %v\n", e, err)
+		}
+		s := fg.Stats()
+		for _, h := range s.Hubs {
+			if h.Name == "double" && (h.Fires < int64(len(arr)) || h.UserTime < time.Duration(len(arr))*time.Millisecond) {
+				t.Fatalf("ERROR Parallelism with %v executor Stats for double %+v\n", e, h)
+			}
+		}
+		for _, p := range s.Pipes {
+			if p.Name == "x" && p.Tokens < int64(len(arr)) {
+				t.Fatalf("ERROR Parallelism with %v executor Stats for pipe x %+v\n", e, p)
+			}
+		}
+		if most > 4 || e == flowgraph.Sequential && most != 1 || e != flowgraph.Sequential && most < 2 {
+			t.Fatalf("ERROR Parallelism with %v executor ran %d copies at once\n", e, most)
+		}
+	}
+	fmt.Printf("END:    TestParallelism\n")
 }
 
 /*=====================================================================*/
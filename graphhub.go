@@ -3,6 +3,7 @@ package flowgraph
 import (
 	"github.com/vectaport/fgbase"
 
+	"context"
 	"fmt"
 )
 
@@ -15,6 +16,13 @@ type GraphHub interface {
 	// Loop builds a conditional iterator for a while or during loop
 	Loop()
 
+	// NumBodyHub returns the number of hubs in the body, the ones not added by Loop
+	NumBodyHub() int
+
+	// SetLoopCapacity sets the capacity of the pipes added by Loop, which
+	// bounds how many values circulate in the loop at once
+	SetLoopCapacity(n int)
+
 	// Link links an internal pipe to an external pipe
 	Link(in, ex Pipe)
 
@@ -31,6 +39,7 @@ type graphhub struct {
 	fg       Flowgraph
 	isources []Pipe
 	iresults []Pipe
+	nbody    int
 }
 
 // Title returns the title of this flowgraph
@@ -43,7 +52,7 @@ func (gh *graphhub) Name() string {
 	return gh.hub.Name()
 }
 
-// SetName sets the name of this hub
+// SetName sets the name of this hub, suffixed if another hub has it
 func (gh *graphhub) SetName(name string) {
 	gh.hub.SetName(name)
 }
@@ -73,6 +82,11 @@ func (gh *graphhub) NewHub(name string, code HubCode, init interface{}) Hub {
 	return gh.fg.NewHub(name, code, init)
 }
 
+// TryNewHub is NewHub returning an error instead of panicking
+func (gh *graphhub) TryNewHub(name string, code HubCode, init interface{}) (Hub, error) {
+	return gh.fg.TryNewHub(name, code, init)
+}
+
 // NewPipe returns a new unconnected pipe
 func (gh *graphhub) NewPipe(name string) Pipe {
 	return gh.fg.NewPipe(name)
@@ -83,6 +97,11 @@ func (gh *graphhub) NewGraphHub(name string, code HubCode) GraphHub {
 	return gh.fg.NewGraphHub(name, code)
 }
 
+// TryNewGraphHub is NewGraphHub returning an error instead of panicking
+func (gh *graphhub) TryNewGraphHub(name string, code HubCode) (GraphHub, error) {
+	return gh.fg.TryNewGraphHub(name, code)
+}
+
 // FindHub finds a hub by name
 func (gh *graphhub) FindHub(name string) Hub {
 	return gh.fg.FindHub(name)
@@ -93,6 +112,16 @@ func (gh *graphhub) FindPipe(name string) Pipe {
 	return gh.fg.FindPipe(name)
 }
 
+// WalkHubs calls f for every hub inside, with its dotted path
+func (gh *graphhub) WalkHubs(f func(path string, h Hub) bool) {
+	gh.fg.WalkHubs(f)
+}
+
+// WalkPipes calls f for every pipe inside, with its dotted path
+func (gh *graphhub) WalkPipes(f func(path string, p Pipe) bool) {
+	gh.fg.WalkPipes(f)
+}
+
 // Connect connects two hubs via named (string) or indexed (int) ports
 func (gh *graphhub) Connect(
 	upstream Hub, upstreamPort interface{},
@@ -109,9 +138,44 @@ func (gh *graphhub) ConnectInit(
 	return gh.fg.ConnectInit(upstream, upstreamPort, dnstream, dnstreamPort, init)
 }
 
+// TryConnect is Connect returning an error instead of panicking
+func (gh *graphhub) TryConnect(
+	upstream Hub, upstreamPort interface{},
+	dnstream Hub, dnstreamPort interface{}) (Pipe, error) {
+	return gh.fg.TryConnect(upstream, upstreamPort, dnstream, dnstreamPort)
+}
+
+// TryConnectInit is ConnectInit returning an error instead of panicking
+func (gh *graphhub) TryConnectInit(
+	upstream Hub, upstreamPort interface{},
+	dnstream Hub, dnstreamPort interface{},
+	init interface{}) (Pipe, error) {
+	return gh.fg.TryConnectInit(upstream, upstreamPort, dnstream, dnstreamPort, init)
+}
+
+// Options returns the options of the internal flowgraph
+func (gh *graphhub) Options() Options {
+	return gh.fg.Options()
+}
+
+// SetOptions configures the internal flowgraph
+func (gh *graphhub) SetOptions(o Options) {
+	gh.fg.SetOptions(o)
+}
+
 // Run runs the flowgraph
-func (gh *graphhub) Run() {
-	gh.fg.Run()
+func (gh *graphhub) Run() error {
+	return gh.fg.Run()
+}
+
+// SetDefaultErrorPolicy sets the error policy for internal hubs without their own
+func (gh *graphhub) SetDefaultErrorPolicy(p ErrorPolicy) {
+	gh.fg.SetDefaultErrorPolicy(p)
+}
+
+// RunContext runs the flowgraph until every hub has exited or ctx is done
+func (gh *graphhub) RunContext(ctx context.Context) error {
+	return gh.fg.RunContext(ctx)
 }
 
 // Tracef for debug trace printing.  Uses atomic log mechanism.
@@ -139,6 +203,16 @@ func (gh *graphhub) Result(port interface{}) Pipe {
 	return gh.hub.Result(port)
 }
 
+// TrySource is Source returning an error instead of panicking
+func (gh *graphhub) TrySource(port interface{}) (Pipe, error) {
+	return gh.hub.TrySource(port)
+}
+
+// TryResult is Result returning an error instead of panicking
+func (gh *graphhub) TryResult(port interface{}) (Pipe, error) {
+	return gh.hub.TryResult(port)
+}
+
 // SetSource sets a pipe on a source port selected by string or int
 func (gh *graphhub) SetSource(port interface{}, s Pipe) Hub {
 	return gh.hub.SetSource(port, s)
@@ -225,6 +299,17 @@ func (gh *graphhub) HubCode() HubCode {
 	return gh.hub.HubCode()
 }
 
+// ErrorPolicy returns the default error policy of internal hubs
+func (gh *graphhub) ErrorPolicy() ErrorPolicy {
+	return gh.fg.(*flowgraph).errorPolicy(nil)
+}
+
+// SetErrorPolicy sets the default error policy of internal hubs
+func (gh *graphhub) SetErrorPolicy(p ErrorPolicy) Hub {
+	gh.fg.SetDefaultErrorPolicy(p)
+	return gh
+}
+
 // Flowgraph returns associated flowgraph interface
 func (gh *graphhub) Flowgraph() Flowgraph {
 	return gh.hub.Flowgraph()
@@ -240,7 +325,14 @@ func (gh *graphhub) Base() interface{} {
 	return gh.hub.Base()
 }
 
-// Loop builds a conditional iterator around a hub or flowgraph with dangling edges
+// Loop builds a conditional iterator around a hub or flowgraph with dangling edges.
+// Dangling sources and results, counting exposed ones, are numbered in order and
+// dangling result i is recirculated to dangling source i.  Sources past the last
+// result are loop invariants replayed on every iteration.  Result i leaves the loop
+// by GraphHub result i, and for While result 0 is the termination condition too.
+// Loop panics if there are more results than sources, or if a result has no
+// GraphHub result port, so call ConnectResults first, with a Sink pipe for a
+// result not wanted.
 func (gh *graphhub) Loop() {
 
 	if gh.HubCode() != While && gh.HubCode() != During {
@@ -292,45 +384,69 @@ func (gh *graphhub) Loop() {
 		gh.iresults = nil
 	}
 
-	if ns != nr {
-		gh.Panicf("ns!=nr not yet supported (ns=%d,nr=%d)\n", ns, nr)
+	if ns == 0 || nr == 0 {
+		gh.Panicf("Loop needs at least one dangling input and output (ns=%d,nr=%d)\n", ns, nr)
+	}
+	if nr > ns {
+		gh.Panicf("Loop result %s has no source to recirculate to (ns=%d,nr=%d)\n", resultName(outs[ns], outsPort[ns]), ns, nr)
 	}
+	if nr > gh.NumResult() {
+		gh.Panicf("Loop result %s has no GraphHub result port (nr=%d,NumResult=%d)\n", resultName(outs[gh.NumResult()], outsPort[gh.NumResult()]), nr, gh.NumResult())
+	}
+
+	gh.nbody = gh.NumHub()
+	m := ns
+	during := gh.HubCode() == During
 
 	wait := gh.NewHub(gh.Name()+"Wait", Wait, nil).
 		SetNumSource(ns + 1).
 		SetNumResult(ns)
 
-	var cross Hub
+	cross := gh.NewHub(gh.Name()+"Cross", Cross, nil).
+		SetNumSource(m * 2).
+		SetNumResult(m * 2)
 
-	if gh.HubCode() == While {
-		cross = gh.NewHub(gh.Name()+"Cross", Cross, nil).
-			SetNumSource(ns * 2).
-			SetNumResult(ns * 2)
-	}
-
-	for i := 0; i < ns; i++ {
-		switch gh.HubCode() {
+	for i := 0; i < m; i++ {
 
-		case While:
-			gh.Connect(wait, i, cross, i)
-			gh.Connect(outs[i], outsPort[i], cross, i+ns)
-			gh.Connect(cross, i+ns, ins[i], insPort[i])
+		// into the loop
+		gh.Connect(wait, i, cross, i)
 
-			if i > 0 {
-				continue
+		// around the loop
+		if i < nr {
+			if _, ok := outs[i].(GraphHub); ok && during {
+				// a nested GraphHub links its result pipe when flattened, so
+				// pass it on by a hub of its own before exposing it again
+				pass := gh.NewHub(fmt.Sprintf("%sOut%d", gh.Name(), i), Pass, nil)
+				gh.Connect(outs[i], outsPort[i], pass, 0)
+				outs[i], outsPort[i] = pass, 0
+			}
+			out := gh.Connect(outs[i], outsPort[i], cross, i+m)
+			if during {
+				gh.ExposeResult(out) // every iteration is output
 			}
+		}
+		gh.Connect(cross, i+m, ins[i], insPort[i])
+		if i >= nr {
+			invariant := gh.NewHub(fmt.Sprintf("%sInvariant%d", gh.Name(), i), Pass, nil)
+			gh.Connect(cross, i+m, invariant, 0)
+			gh.Connect(invariant, 0, cross, i+m)
+		}
 
-			termc := gh.ConnectInit(cross, 0, wait, ns, 0) // termination condition recycled but also needs to be output
+		// out of the loop
+		if i == 0 {
+			termc := gh.ConnectInit(cross, 0, wait, ns, 0) // termination condition recycled
 			termc.Base().(*fgbase.Edge).Val = nil          // remove initialization condition from termination condition
-			gh.ExposeResult(termc)
-
-		default:
-			gh.Panicf("Uknown HubCode %q for GraphHub %q\n", gh.HubCode(), gh.Name())
-
+			if !during {
+				gh.ExposeResult(termc) // but also needs to be output
+			}
+			continue
+		}
+		if during || i >= nr {
+			cross.SetResult(i, gh.NewPipe("").Sink()) // invariant or already output
 		}
 	}
 	if fgbase.TraceLevel >= fgbase.V {
-		fmt.Printf("// While loop %q internals:\n", gh.Name())
+		fmt.Printf("// %s loop %q internals:\n", gh.HubCode(), gh.Name())
 		for i := 0; i < gh.NumHub(); i++ {
 			fmt.Printf("// %s\n", gh.Hub(i).Base().(*fgbase.Node).String())
 		}
@@ -339,6 +455,44 @@ func (gh *graphhub) Loop() {
 
 }
 
+// resultName names result port i of h for a panic message
+func resultName(h Hub, i int) string {
+	if nm := h.ResultNames(); i < len(nm) && nm[i] != "" {
+		return fmt.Sprintf("%s.%s", h.Name(), nm[i])
+	}
+	return fmt.Sprintf("%s[%d]", h.Name(), i)
+}
+
+// NumBodyHub returns the number of hubs in the body, the ones not added by Loop
+func (gh *graphhub) NumBodyHub() int {
+	if gh.nbody == 0 {
+		return gh.NumHub()
+	}
+	return gh.nbody
+}
+
+// SetLoopCapacity sets the capacity of the pipes to and from the hubs
+// added by Loop, and so how many values the Wait hub lets into the loop
+// before the first one comes back around
+func (gh *graphhub) SetLoopCapacity(n int) {
+	if gh.nbody == 0 {
+		gh.Panicf("SetLoopCapacity needs Loop called first\n")
+	}
+	for i := gh.nbody; i < gh.NumHub(); i++ {
+		h := gh.Hub(i)
+		for j := 0; j < h.NumSource(); j++ {
+			if s := h.Source(j); !s.Empty() && !s.IsConst() {
+				s.SetCapacity(n)
+			}
+		}
+		for j := 0; j < h.NumResult(); j++ {
+			if r := h.Result(j); !r.Empty() && !r.IsSink() {
+				r.SetCapacity(n)
+			}
+		}
+	}
+}
+
 // Link links an internal pipe to an external pipe
 func (gh *graphhub) Link(in, ex Pipe) {
 
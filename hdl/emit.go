@@ -0,0 +1,301 @@
+package hdl
+
+import (
+	"github.com/vectaport/fgbase"
+	"github.com/vectaport/flowgraph"
+
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Emit writes fg as Flowgraph HDL text, one statement per hub in the order
+// they were added.  GraphHub bodies are written in braces, leaving out the
+// hubs added by Loop.  Hubs are written under their own names, so parsing
+// the text again needs a Registry that knows them.  Constant and Array
+// hubs are written with their values.  Unnamed pipes are named e0, e1,
+// and so on.
+func Emit(w io.Writer, fg flowgraph.Flowgraph) error {
+	e := &emitter{w: w, used: make(map[string]bool), inits: make(map[string]bool)}
+	e.collect(fg)
+	e.graph(fg, &scope{}, "")
+	return e.err
+}
+
+// emitter holds the names given to pipes while writing
+type emitter struct {
+	w     io.Writer
+	err   error
+	used  map[string]bool
+	pipes []flowgraph.Pipe
+	names []string
+	inits map[string]bool
+	next  int
+}
+
+// scope is a GraphHub body being written, with the hubs added by Loop
+type scope struct {
+	gh         flowgraph.GraphHub
+	loop       map[*fgbase.Node]bool
+	nsrc, nres int
+}
+
+// collect notes every pipe name in use, so generated names do not clash
+func (e *emitter) collect(fg flowgraph.Flowgraph) {
+	for i := 0; i < fg.NumPipe(); i++ {
+		e.used[fg.Pipe(i).Name()] = true
+	}
+	for i := 0; i < fg.NumHub(); i++ {
+		if gh, ok := fg.Hub(i).(flowgraph.GraphHub); ok {
+			e.collect(gh)
+		}
+	}
+}
+
+// printf writes formatted text, keeping the first error
+func (e *emitter) printf(format string, v ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, v...)
+}
+
+// graph writes the hubs of a flowgraph or GraphHub body
+func (e *emitter) graph(fg flowgraph.Flowgraph, sc *scope, indent string) {
+	n := fg.NumHub()
+	if sc.gh != nil {
+		n = sc.gh.NumBodyHub()
+		sc.loop = make(map[*fgbase.Node]bool)
+		for i := n; i < fg.NumHub(); i++ {
+			sc.loop[fg.Hub(i).Base().(*fgbase.Node)] = true
+		}
+	}
+	for i := 0; i < n && e.err == nil; i++ {
+		e.hub(fg.Hub(i), sc, indent)
+	}
+}
+
+// hub writes one hub statement, and its body if a GraphHub
+func (e *emitter) hub(h flowgraph.Hub, sc *scope, indent string) {
+	sources := make([]string, h.NumSource())
+	for i := range sources {
+		sources[i] = e.source(h.Source(i), sc)
+	}
+	results := make([]string, h.NumResult())
+	for i := range results {
+		results[i] = e.result(h.Result(i), sc)
+	}
+	if e.err != nil {
+		return
+	}
+
+	name := h.Name()
+	if c := h.HubCode(); c == flowgraph.Constant || c == flowgraph.Array {
+		name += "=" + e.init(h)
+	}
+	e.printf("%s%s(%s)(%s)", indent, name,
+		ports(sources, h.SourceNames()), ports(results, h.ResultNames()))
+	gh, ok := h.(flowgraph.GraphHub)
+	if !ok {
+		e.printf("\n")
+		return
+	}
+	e.printf(" {\n")
+	e.graph(gh, &scope{gh: gh}, indent+"\t")
+	e.printf("%s}\n", indent)
+}
+
+// ports joins a list of ports, naming each if every port has a name
+func ports(args, names []string) string {
+	named := len(names) == len(args)
+	for _, nm := range names {
+		named = named && nm != ""
+	}
+	if named {
+		for i := range args {
+			args[i] = "." + names[i] + "(" + args[i] + ")"
+		}
+	}
+	return strings.Join(args, ", ")
+}
+
+// source returns the text for a source pipe: a literal if constant,
+// otherwise its name, with its initial value the first time
+func (e *emitter) source(p flowgraph.Pipe, sc *scope) string {
+	if p.Empty() {
+		return e.dangling(sc, true)
+	}
+	v := p.Base().(*fgbase.Edge).Val
+	if p.IsConst() {
+		return e.literal(v)
+	}
+	name := e.name(p, sc)
+	if v != nil && !e.inits[name] {
+		e.inits[name] = true
+		name += "=" + e.literal(v)
+	}
+	return name
+}
+
+// result returns the text for a result pipe
+func (e *emitter) result(p flowgraph.Pipe, sc *scope) string {
+	if p.Empty() {
+		return e.dangling(sc, false)
+	}
+	return e.name(p, sc)
+}
+
+// dangling names an unconnected port inside a GraphHub after the next
+// GraphHub port, or gives it a new name elsewhere
+func (e *emitter) dangling(sc *scope, source bool) string {
+	if sc.gh != nil {
+		if source && sc.nsrc < sc.gh.NumSource() {
+			sc.nsrc++
+			return e.name(sc.gh.Source(sc.nsrc-1), nil)
+		}
+		if !source && sc.nres < sc.gh.NumResult() {
+			sc.nres++
+			return e.name(sc.gh.Result(sc.nres-1), nil)
+		}
+	}
+	return e.newName()
+}
+
+// name returns the name of a pipe.  Inside a loop a pipe to or from the
+// Cross hub added by Loop stands for a GraphHub port and takes its name.
+func (e *emitter) name(p flowgraph.Pipe, sc *scope) string {
+	if sc != nil && sc.gh != nil {
+		if q := sc.port(p); q != nil {
+			return e.name(q, nil)
+		}
+	}
+	for i, q := range e.pipes {
+		if q.Same(p) {
+			return e.names[i]
+		}
+	}
+	name := p.Name()
+	if name == "" {
+		name = e.newName()
+	}
+	e.pipes = append(e.pipes, p)
+	e.names = append(e.names, name)
+	return name
+}
+
+// newName returns a pipe name not otherwise in use
+func (e *emitter) newName() string {
+	for {
+		name := fmt.Sprintf("e%d", e.next)
+		e.next++
+		if !e.used[name] {
+			e.used[name] = true
+			return name
+		}
+	}
+}
+
+// port returns the GraphHub port a body pipe stands for, if any
+func (sc *scope) port(p flowgraph.Pipe) flowgraph.Pipe {
+	for i := 0; i < p.NumUpstream(); i++ {
+		if h := p.Upstream(i); sc.isCross(h) {
+			m := h.NumResult() / 2
+			if j := h.ResultIndex(p) - m; j >= 0 && j < sc.gh.NumSource() {
+				return sc.gh.Source(j)
+			}
+		}
+	}
+	for i := 0; i < p.NumDownstream(); i++ {
+		if h := p.Downstream(i); sc.isCross(h) {
+			m := h.NumSource() / 2
+			if j := h.SourceIndex(p) - m; j >= 0 && j < sc.gh.NumResult() {
+				return sc.gh.Result(j)
+			}
+		}
+	}
+	return nil
+}
+
+// isCross returns true if h is the Cross hub added by Loop
+func (sc *scope) isCross(h flowgraph.Hub) bool {
+	return h != nil && !h.Empty() && h.HubCode() == flowgraph.Cross && sc.loop[h.Base().(*fgbase.Node)]
+}
+
+// init returns the text for the value of a Constant hub, or the values
+// of an Array hub
+func (e *emitter) init(h flowgraph.Hub) string {
+	v := h.Base().(*fgbase.Node).Aux
+	if h.HubCode() == flowgraph.Constant {
+		return e.literal(v)
+	}
+	vals, ok := v.([]interface{})
+	if !ok {
+		if e.err == nil {
+			e.err = fmt.Errorf("no Flowgraph HDL values for Array hub %q of type %T", h.Name(), v)
+		}
+		return ""
+	}
+	lits := make([]string, len(vals))
+	for i := range vals {
+		lits[i] = e.literal(vals[i])
+	}
+	return "[" + strings.Join(lits, ", ") + "]"
+}
+
+// literal returns the text for a constant or initial value.  Every int
+// and float type is written, though they parse back as int and float64.
+func (e *emitter) literal(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int8:
+		return strconv.FormatInt(int64(v), 10)
+	case int16:
+		return strconv.FormatInt(int64(v), 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float32:
+		if s, ok := float(float64(v), 32); ok {
+			return s
+		}
+	case float64:
+		if s, ok := float(v, 64); ok {
+			return s
+		}
+	case string:
+		return strconv.Quote(v)
+	}
+	if e.err == nil {
+		e.err = fmt.Errorf("no Flowgraph HDL literal for %v of type %T", v, v)
+	}
+	return ""
+}
+
+// float returns the text for a finite float, with a decimal point or
+// exponent so it parses back as a float
+func float(v float64, bits int) (string, bool) {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return "", false
+	}
+	s := strconv.FormatFloat(v, 'g', -1, bits)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s, true
+}
@@ -0,0 +1,601 @@
+// Package hdl reads the Flowgraph HDL text format into a Flowgraph, and
+// writes a Flowgraph back out as text.
+//
+// Each statement is a hub, its name followed by a list of sources and a
+// list of results, the last optional:
+//
+//	sub(oldval, 1)(newval)
+//	wait(.A(firstval), .B(lastval=0))(.X(oldval))
+//	while(firstval)(lastval) {
+//	        sub(firstval, 1)(lastval)
+//	}
+//
+// Pipes are named by identifiers, and connect every hub that names them.
+// A literal source is a constant pipe, and name=literal sets the initial
+// value of a pipe.  A port given as .A(pipe) is named A.  Commas between
+// ports are optional.  While, During, and Graph hubs have a body in braces,
+// where their source and result names stand for the pipes in and out.
+// A Constant hub is given its value as name=literal, and an Array hub its
+// values as name=[literal, ...]:
+//
+//	twelve=12()(firstval)
+//	vals=[1, 2.5, "three", true]()(val)
+//
+// Literals are ints, floats, quoted strings, true, and false.
+// Comments are in // or /* */ form.
+package hdl
+
+import (
+	"github.com/vectaport/flowgraph"
+
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Error is an error at a line and column of Flowgraph HDL text
+type Error struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// Parse parses Flowgraph HDL text into a titled Flowgraph, looking up hub
+// names in r
+func Parse(title, src string, r *Registry) (flowgraph.Flowgraph, error) {
+	p := &parser{lex: lexer{src: src, line: 1, col: 1}}
+	p.next()
+	stmts, err := p.stmts()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+
+	fg := flowgraph.New(title)
+	b := &builder{r}
+	if err := b.build(fg, stmts, nil, nil); err != nil {
+		return nil, err
+	}
+	return fg, nil
+}
+
+/*=====================================================================*/
+
+type tokenKind int
+
+const (
+	tEOF tokenKind = iota
+	tIdent
+	tNumber
+	tString
+	tPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+	col  int
+}
+
+func (t token) String() string {
+	if t.kind == tEOF {
+		return "end of text"
+	}
+	return strconv.Quote(t.text)
+}
+
+type lexer struct {
+	src  string
+	off  int
+	line int
+	col  int
+}
+
+// advance moves past one byte, keeping count of lines and columns
+func (l *lexer) advance() {
+	if l.src[l.off] == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	l.off++
+}
+
+func (l *lexer) peek(i int) byte {
+	if l.off+i < len(l.src) {
+		return l.src[l.off+i]
+	}
+	return 0
+}
+
+// skip skips white space and comments
+func (l *lexer) skip() error {
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			l.advance()
+		case c == '/' && l.peek(1) == '/':
+			for l.off < len(l.src) && l.src[l.off] != '\n' {
+				l.advance()
+			}
+		case c == '/' && l.peek(1) == '*':
+			line, col := l.line, l.col
+			l.advance()
+			l.advance()
+			for !(l.peek(0) == '*' && l.peek(1) == '/') {
+				if l.off >= len(l.src) {
+					return &Error{line, col, "unterminated comment"}
+				}
+				l.advance()
+			}
+			l.advance()
+			l.advance()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) token() (token, error) {
+	if err := l.skip(); err != nil {
+		return token{}, err
+	}
+	t := token{line: l.line, col: l.col}
+	if l.off >= len(l.src) {
+		return t, nil
+	}
+	start := l.off
+	c := l.src[l.off]
+	switch {
+	case isIdent(c, true):
+		for l.off < len(l.src) && isIdent(l.src[l.off], false) {
+			l.advance()
+		}
+		t.kind = tIdent
+	case isDigit(c) || c == '-' && isDigit(l.peek(1)):
+		l.advance()
+		for l.off < len(l.src) && (isIdent(l.src[l.off], false) || l.src[l.off] == '.' || isSign(l.src[l.off]) && isExp(l.src[l.off-1])) {
+			l.advance()
+		}
+		t.kind = tNumber
+	case c == '"':
+		l.advance()
+		for l.peek(0) != '"' {
+			if l.off >= len(l.src) || l.src[l.off] == '\n' {
+				return t, &Error{t.line, t.col, "unterminated string"}
+			}
+			if l.src[l.off] == '\\' {
+				l.advance()
+			}
+			l.advance()
+		}
+		l.advance()
+		t.kind = tString
+	case strings.IndexByte("(){}[],.=", c) >= 0:
+		l.advance()
+		t.kind = tPunct
+	default:
+		return t, &Error{t.line, t.col, fmt.Sprintf("unexpected character %q", c)}
+	}
+	t.text = l.src[start:l.off]
+	return t, nil
+}
+
+func isIdent(c byte, first bool) bool {
+	return c == '_' || unicode.IsLetter(rune(c)) || !first && isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSign(c byte) bool {
+	return c == '-' || c == '+'
+}
+
+func isExp(c byte) bool {
+	return c == 'e' || c == 'E'
+}
+
+/*=====================================================================*/
+
+// stmt is a hub with its ports, the value of a Constant or Array hub,
+// and the body of a GraphHub
+type stmt struct {
+	tok     token
+	init    interface{}
+	hasInit bool
+	sources []*arg
+	results []*arg
+	body    []*stmt
+	hasBody bool
+}
+
+// arg is a pipe or a constant on a port
+type arg struct {
+	tok    token
+	port   string
+	pipe   string
+	val    interface{}
+	hasVal bool
+}
+
+type parser struct {
+	lex lexer
+	tok token
+	err error
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.token()
+}
+
+func (p *parser) errorf(format string, v ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	return &Error{p.tok.line, p.tok.col, fmt.Sprintf(format, v...)}
+}
+
+func (p *parser) is(punct string) bool {
+	return p.err == nil && p.tok.kind == tPunct && p.tok.text == punct
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.is(punct) {
+		return p.errorf("expected %q, found %s", punct, p.tok)
+	}
+	p.next()
+	return nil
+}
+
+// stmts parses statements up to the end of the text or a closing brace
+func (p *parser) stmts() ([]*stmt, error) {
+	var stmts []*stmt
+	for p.err == nil && p.tok.kind != tEOF && !p.is("}") {
+		s, err := p.stmt()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return stmts, nil
+}
+
+func (p *parser) stmt() (*stmt, error) {
+	if p.tok.kind != tIdent {
+		return nil, p.errorf("expected hub name, found %s", p.tok)
+	}
+	s := &stmt{tok: p.tok}
+	p.next()
+
+	var err error
+	if p.is("=") {
+		p.next()
+		if s.init, err = p.init(); err != nil {
+			return nil, err
+		}
+		s.hasInit = true
+	}
+	if s.sources, err = p.args(); err != nil {
+		return nil, err
+	}
+	if p.is("(") {
+		if s.results, err = p.args(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is("{") {
+		p.next()
+		if s.body, err = p.stmts(); err != nil {
+			return nil, err
+		}
+		if err = p.expect("}"); err != nil {
+			return nil, err
+		}
+		s.hasBody = true
+	}
+	return s, p.err
+}
+
+// args parses a parenthesized list of ports
+func (p *parser) args() ([]*arg, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []*arg
+	for !p.is(")") {
+		if p.err != nil || p.tok.kind == tEOF {
+			return nil, p.errorf("expected \")\", found %s", p.tok)
+		}
+		a := &arg{tok: p.tok}
+		if p.is(".") {
+			p.next()
+			if p.tok.kind != tIdent {
+				return nil, p.errorf("expected port name, found %s", p.tok)
+			}
+			a.port = p.tok.text
+			p.next()
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			if err := p.value(a); err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		} else if err := p.value(a); err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if p.is(",") {
+			p.next()
+		}
+	}
+	p.next()
+	return args, p.err
+}
+
+// value parses a pipe name, a pipe name with an initial value, or a constant
+func (p *parser) value(a *arg) error {
+	if p.tok.kind == tIdent && p.tok.text != "true" && p.tok.text != "false" {
+		a.pipe = p.tok.text
+		p.next()
+		if !p.is("=") {
+			return p.err
+		}
+		p.next()
+	}
+	v, err := p.literal()
+	if err != nil {
+		return err
+	}
+	a.val, a.hasVal = v, true
+	return nil
+}
+
+// init parses the value of a Constant hub, or the bracketed values of an
+// Array hub
+func (p *parser) init() (interface{}, error) {
+	if !p.is("[") {
+		return p.literal()
+	}
+	p.next()
+	vals := []interface{}{}
+	for !p.is("]") {
+		v, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+		if p.is(",") {
+			p.next()
+		}
+	}
+	p.next()
+	return vals, p.err
+}
+
+func (p *parser) literal() (interface{}, error) {
+	t := p.tok
+	var v interface{}
+	var err error
+	switch {
+	case t.kind == tNumber:
+		if v, err = strconv.Atoi(t.text); err != nil {
+			v, err = strconv.ParseFloat(t.text, 64)
+		}
+	case t.kind == tString:
+		v, err = strconv.Unquote(t.text)
+	case t.kind == tIdent && (t.text == "true" || t.text == "false"):
+		v = t.text == "true"
+	default:
+		return nil, p.errorf("expected pipe name or value, found %s", t)
+	}
+	if err != nil {
+		return nil, p.errorf("bad value %s", t)
+	}
+	p.next()
+	return v, p.err
+}
+
+/*=====================================================================*/
+
+type builder struct {
+	r *Registry
+}
+
+func errorAt(t token, format string, v ...interface{}) error {
+	return &Error{t.line, t.col, fmt.Sprintf(format, v...)}
+}
+
+// build adds the hubs of stmts to fg.  For the body of a GraphHub, gh is
+// the GraphHub and outer is its statement.
+func (b *builder) build(fg flowgraph.Flowgraph, stmts []*stmt, gh flowgraph.GraphHub, outer *stmt) error {
+	pipes := make(map[string]flowgraph.Pipe)
+
+	var ins, outs []flowgraph.Pipe
+	if gh != nil {
+		for _, a := range outer.sources {
+			pipes[a.pipe] = fg.NewPipe(a.pipe)
+			ins = append(ins, pipes[a.pipe])
+		}
+		for _, a := range outer.results {
+			if _, ok := pipes[a.pipe]; ok {
+				return errorAt(a.tok, "pipe %q is both a source and a result of %q", a.pipe, outer.tok.text)
+			}
+			pipes[a.pipe] = fg.NewPipe(a.pipe)
+			outs = append(outs, pipes[a.pipe])
+		}
+	}
+
+	// initial values are set before any hub is connected to their pipes
+	for _, s := range stmts {
+		for _, a := range append(s.sources, s.results...) {
+			if a.pipe == "" || !a.hasVal {
+				continue
+			}
+			if _, ok := pipes[a.pipe]; !ok {
+				pipes[a.pipe] = fg.NewPipe(a.pipe)
+			}
+			pipes[a.pipe].Init(a.val)
+		}
+	}
+
+	for _, s := range stmts {
+		if err := b.hub(fg, s, pipes); err != nil {
+			return err
+		}
+	}
+
+	if gh == nil {
+		return nil
+	}
+	for _, p := range ins {
+		if p.NumDownstream() == 0 {
+			return errorAt(outer.tok, "source %q not used inside %q", p.Name(), outer.tok.text)
+		}
+		gh.ExposeSource(p)
+	}
+	for _, p := range outs {
+		if p.NumUpstream() == 0 {
+			return errorAt(outer.tok, "result %q not made inside %q", p.Name(), outer.tok.text)
+		}
+		gh.ExposeResult(p)
+	}
+	if c := gh.HubCode(); c == flowgraph.While || c == flowgraph.During {
+		if len(outs) > len(ins) {
+			return errorAt(outer.tok, "%s hub %q has more results than sources", c, outer.tok.text)
+		}
+		gh.Loop()
+	}
+	return nil
+}
+
+// hub adds the hub of one statement to fg
+func (b *builder) hub(fg flowgraph.Flowgraph, s *stmt, pipes map[string]flowgraph.Pipe) error {
+	e, ok := b.r.lookup(s.tok.text)
+	if !ok {
+		return errorAt(s.tok, "unknown hub %q", s.tok.text)
+	}
+
+	sources, snames, err := b.ports(fg, s.sources, pipes, false)
+	if err != nil {
+		return err
+	}
+	results, rnames, err := b.ports(fg, s.results, pipes, true)
+	if err != nil {
+		return err
+	}
+
+	var h flowgraph.Hub
+	var gh flowgraph.GraphHub
+	switch e.code {
+	case flowgraph.Graph, flowgraph.While, flowgraph.During:
+		if !s.hasBody {
+			return errorAt(s.tok, "%s hub %q needs a body", e.code, s.tok.text)
+		}
+		if s.hasInit {
+			return checkInit(s, e.code)
+		}
+		for _, a := range s.sources {
+			if a.pipe == "" {
+				return errorAt(a.tok, "source of %s hub %q can not be the constant %v", e.code, s.tok.text, a.val)
+			}
+		}
+		gh = fg.NewGraphHub(s.tok.text, e.code)
+		h = gh
+	default:
+		if s.hasBody {
+			return errorAt(s.tok, "%s hub %q can not have a body", e.code, s.tok.text)
+		}
+		var init interface{}
+		if e.init != nil {
+			init = e.init()
+		}
+		if s.hasInit {
+			if err := checkInit(s, e.code); err != nil {
+				return err
+			}
+			init = s.init
+		}
+		var err error
+		if h, err = fg.TryNewHub(s.tok.text, e.code, init); err != nil {
+			return errorAt(s.tok, "%v", err)
+		}
+	}
+
+	h.ConnectSources(sources...).ConnectResults(results...)
+	if snames != nil {
+		h.SetSourceNames(snames...)
+	}
+	if rnames != nil {
+		h.SetResultNames(rnames...)
+	}
+	if gh != nil {
+		return b.build(gh, s.body, gh, s)
+	}
+	return nil
+}
+
+// checkInit checks that a hub given a value is a Constant hub given one
+// literal or an Array hub given a list
+func checkInit(s *stmt, code flowgraph.HubCode) error {
+	_, list := s.init.([]interface{})
+	switch {
+	case code == flowgraph.Constant && list:
+		return errorAt(s.tok, "Constant hub %q needs one value, not a list", s.tok.text)
+	case code == flowgraph.Array && !list:
+		return errorAt(s.tok, "Array hub %q needs a list of values", s.tok.text)
+	case code != flowgraph.Constant && code != flowgraph.Array:
+		return errorAt(s.tok, "%s hub %q can not be given a value", code, s.tok.text)
+	}
+	return nil
+}
+
+// ports returns the pipes for a list of ports, and their names if named
+func (b *builder) ports(fg flowgraph.Flowgraph, args []*arg, pipes map[string]flowgraph.Pipe, results bool) ([]flowgraph.Pipe, []string, error) {
+	var ps []flowgraph.Pipe
+	var names []string
+	for i, a := range args {
+		if (a.port != "") != (args[0].port != "") {
+			return nil, nil, errorAt(a.tok, "ports must be all named or all unnamed")
+		}
+		if a.port != "" {
+			names = append(names, a.port)
+		}
+
+		if a.pipe == "" {
+			if results {
+				return nil, nil, errorAt(a.tok, "result %d can not be the constant %v", i, a.val)
+			}
+			ps = append(ps, fg.NewPipe("").Const(a.val))
+			continue
+		}
+		p, ok := pipes[a.pipe]
+		if !ok {
+			p = fg.NewPipe(a.pipe)
+			pipes[a.pipe] = p
+		}
+		ps = append(ps, p)
+	}
+	return ps, names, nil
+}
@@ -0,0 +1,258 @@
+package hdl_test
+
+import (
+	"github.com/vectaport/fgbase"
+	"github.com/vectaport/flowgraph"
+	"github.com/vectaport/flowgraph/hdl"
+
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type constant struct {
+	v interface{}
+}
+
+func (c *constant) Retrieve(n flowgraph.Hub) (result interface{}, err error) {
+	return c.v, nil
+}
+
+type sinkWant struct {
+	t    *testing.T
+	want interface{}
+	cnt  int
+}
+
+func (st *sinkWant) Sink(source []interface{}) {
+	if source[0] != st.want {
+		st.t.Fatalf("ERROR sink got %v, expected %v\n", source[0], st.want)
+	}
+	st.cnt++
+}
+
+func TestParse(t *testing.T) {
+	fmt.Printf("BEGIN:  TestParse\n")
+
+	tests := []struct {
+		name string
+		src  string
+		m, n int
+		want int
+	}{
+		{"Iterator1", `
+// named ports and an initialized pipe
+mval()(.X(firstval))
+wait(.A(firstval),.B(lastval=0))(.X(oldval))
+sub(.A(oldval),.B(1))(.X(newval))
+steer(.A(newval))(.X(lastval),.Y(oldval))
+sink(.A(lastval))()
+`, 10, 0, 0},
+		{"GCD", `
+mval()(mval)
+nval()(nval)
+while(mval, nval)(tcond, gcd) {
+        pass(mval)(gcd)
+        mod(nval, mval)(tcond)
+}
+sink(gcd)
+sink2(tcond)()
+`, 12, 18, 6},
+	}
+
+	for _, test := range tests {
+		r := hdl.NewRegistry()
+		r.RegisterRetriever("mval", func() flowgraph.Retriever { return &constant{test.m} })
+		r.RegisterRetriever("nval", func() flowgraph.Retriever { return &constant{test.n} })
+		s := &sinkWant{t: t, want: test.want}
+		r.Register("sink", flowgraph.Sink, func() interface{} { return s })
+		r.Register("sink2", flowgraph.Sink, nil)
+
+		fg, err := hdl.Parse(test.name, test.src, r)
+		if err != nil {
+			t.Fatalf("ERROR %s:  %v\n", test.name, err)
+		}
+		if test.name == "Iterator1" && fg.FindHub("sink").SourceNames()[0] != "A" {
+			t.Fatalf("ERROR %s sink port not named\n", test.name)
+		}
+		if err := fg.Validate(); err != nil {
+			t.Fatalf("ERROR %s:  %v\n", test.name, err)
+		}
+
+		o := fg.Options()
+		o.RunTime = time.Second
+		o.TraceLevel = fgbase.V
+		fg.SetOptions(o)
+		fg.Run()
+
+		if s.cnt == 0 {
+			t.Fatalf("ERROR %s sink got nothing\n", test.name)
+		}
+	}
+
+	fmt.Printf("END:    TestParse\n")
+}
+
+func TestParseError(t *testing.T) {
+	fmt.Printf("BEGIN:  TestParseError\n")
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"sub(a, 1)(b", "1:12: expected \")\""},
+		{"foo()(x)", "1:1: unknown hub \"foo\""},
+		{"sub(a,\n  %)(b)", "2:3: unexpected character"},
+		{"sub(a, \"one)(b)", "1:8: unterminated string"},
+		{"sub(.A(a), b)(c)", "1:12: ports must be all named"},
+		{"sub(a, 1)(2)", "1:11: result 0 can not be the constant 2"},
+		{"sub(a, 1)(b) {\n}", "1:1: Subtract hub \"sub\" can not have a body"},
+		{"while(a)(b)", "1:1: While hub \"while\" needs a body"},
+		{"while(a)(b) {\n  sub(c, 1)(b)\n}", "1:1: source \"a\" not used inside \"while\""},
+		{"while(a)(b, c) {\n  sub(a, 1)(b)\n  add(a, 1)(c)\n}", "1:1: While hub \"while\" has more results than sources"},
+		{"/* open", "1:1: unterminated comment"},
+		{"sub=1(a, 1)(b)", "1:1: Subtract hub \"sub\" can not be given a value"},
+		{"constant=[1, 2]()(a)", "1:1: Constant hub \"constant\" needs one value, not a list"},
+		{"array=1()(a)", "1:1: Array hub \"array\" needs a list of values"},
+		{"bad()(a)", "1:1: Hub with Retrieve code not given Retriever"},
+	}
+
+	r := hdl.NewRegistry()
+	r.Register("bad", flowgraph.Retrieve, nil)
+	for _, test := range tests {
+		_, err := hdl.Parse("TestParseError", test.src, r)
+		var herr *hdl.Error
+		if !errors.As(err, &herr) || !strings.HasPrefix(err.Error(), test.err) {
+			t.Fatalf("ERROR parse of %q returned %v, expected %s\n", test.src, err, test.err)
+		}
+	}
+	fmt.Printf("END:    TestParseError\n")
+}
+
+func TestEmit(t *testing.T) {
+	fmt.Printf("BEGIN:  TestEmit\n")
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"Iterator1", `
+mval()(.X(firstval))
+wait(.A(firstval),.B(lastval=0))(.X(oldval))
+sub(.A(oldval),.B(1))(.X(newval))
+steer(.A(newval))(.X(lastval),.Y(oldval))
+sink(.A(lastval))()
+`, `mval()(.X(firstval))
+wait(.A(firstval), .B(lastval=0))(.X(oldval))
+sub(.A(oldval), .B(1))(.X(newval))
+steer(.A(newval))(.X(lastval), .Y(oldval))
+sink(.A(lastval))()
+`},
+		{"GCD", `
+mval()(mval)
+nval()(nval)
+while(mval, nval)(tcond, gcd) {
+        pass(mval)(gcd)
+        mod(nval, mval)(tcond)
+}
+sink(gcd)
+sink2(tcond)()
+`, `mval()(mval)
+nval()(nval)
+while(mval, nval)(tcond, gcd) {
+	pass(mval)(gcd)
+	mod(nval, mval)(tcond)
+}
+sink(gcd)()
+sink2(tcond)()
+`},
+		{"Literals", `
+constant=true()(flag)
+array=[1, -2.5, "three", false, 1e-07]()(val)
+wait(.A(val), .B(flag), .C(done=false))(.X(out))
+steer(out, 1.5e+06)(done, last)
+sink(last)()
+`, `constant=true()(flag)
+array=[1, -2.5, "three", false, 1e-07]()(val)
+wait(.A(val), .B(flag), .C(done=false))(.X(out))
+steer(out, 1.5e+06)(done, last)
+sink(last)()
+`},
+	}
+
+	for _, test := range tests {
+		r := hdl.NewRegistry()
+		r.RegisterRetriever("mval", func() flowgraph.Retriever { return &constant{12} })
+		r.RegisterRetriever("nval", func() flowgraph.Retriever { return &constant{18} })
+		r.Register("sink", flowgraph.Sink, nil)
+		r.Register("sink2", flowgraph.Sink, nil)
+
+		src := test.src
+		for pass := 0; pass < 2; pass++ {
+			fg, err := hdl.Parse(test.name, src, r)
+			if err != nil {
+				t.Fatalf("ERROR %s pass %d:  %v\n", test.name, pass, err)
+			}
+			var b strings.Builder
+			if err = hdl.Emit(&b, fg); err != nil {
+				t.Fatalf("ERROR %s pass %d:  %v\n", test.name, pass, err)
+			}
+			if b.String() != test.want {
+				t.Fatalf("ERROR %s pass %d emitted\n%s\nexpected\n%s\n", test.name, pass, b.String(), test.want)
+			}
+			src = b.String()
+		}
+	}
+	fmt.Printf("END:    TestEmit\n")
+}
+
+func TestEmitLoop(t *testing.T) {
+	fmt.Printf("BEGIN:  TestEmitLoop\n")
+	fg := flowgraph.New("TestEmitLoop")
+
+	firstval := fg.NewPipe("firstval")
+	stepval := fg.NewPipe("")
+	lastval := fg.NewPipe("lastval")
+
+	fg.NewHub("twelve", flowgraph.Constant, 12).
+		ConnectResults(firstval)
+	fg.NewHub("three", flowgraph.Constant, 3).
+		ConnectResults(stepval)
+
+	while := fg.NewGraphHub("while", flowgraph.While)
+	while.ConnectSources(firstval, stepval).
+		ConnectResults(lastval)
+	while.NewHub("sub", flowgraph.Subtract, nil)
+	while.Loop()
+
+	fg.NewHub("sink", flowgraph.Sink, nil).
+		ConnectSources(lastval)
+
+	want := `twelve=12()(firstval)
+three=3()(e0)
+while(firstval, e0)(lastval) {
+	sub(firstval, e0)(lastval)
+}
+sink(lastval)()
+`
+	r := hdl.NewRegistry()
+	r.Register("twelve", flowgraph.Constant, func() interface{} { return 12 })
+	r.Register("three", flowgraph.Constant, func() interface{} { return 3 })
+
+	// emit, then parse and emit again
+	for pass := 0; pass < 2; pass++ {
+		var b strings.Builder
+		if err := hdl.Emit(&b, fg); err != nil {
+			t.Fatalf("ERROR EmitLoop pass %d:  %v\n", pass, err)
+		}
+		if b.String() != want {
+			t.Fatalf("ERROR EmitLoop pass %d emitted\n%s\nexpected\n%s\n", pass, b.String(), want)
+		}
+		var err error
+		if fg, err = hdl.Parse("TestEmitLoop", b.String(), r); err != nil {
+			t.Fatalf("ERROR EmitLoop pass %d:  %v\n", pass, err)
+		}
+	}
+	fmt.Printf("END:    TestEmitLoop\n")
+}
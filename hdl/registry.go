@@ -0,0 +1,73 @@
+package hdl
+
+import (
+	"github.com/vectaport/flowgraph"
+
+	"strings"
+)
+
+// Registry maps hub names in Flowgraph HDL to a HubCode and an init arg
+// for NewHub.  A name not registered as is, like "sub1", is looked up
+// again without its trailing digits, and a name like "rand100_1", given
+// by NewHub to a repeated name, without its suffix.
+type Registry struct {
+	entries map[string]entry
+}
+
+type entry struct {
+	code flowgraph.HubCode
+	init func() interface{}
+}
+
+// NewRegistry returns a registry with the HubCodes that need no init arg,
+// and Constant and Array, whose values are given in the text, under their
+// lower case names and the short names sub, mul, div, and mod.
+func NewRegistry() *Registry {
+	r := &Registry{make(map[string]entry)}
+	for _, c := range []flowgraph.HubCode{
+		flowgraph.Wait, flowgraph.Select, flowgraph.Steer, flowgraph.Cross,
+		flowgraph.Array, flowgraph.Constant, flowgraph.Pass, flowgraph.Split, flowgraph.Join, flowgraph.Sink,
+		flowgraph.Graph, flowgraph.While, flowgraph.During,
+		flowgraph.Add, flowgraph.Subtract, flowgraph.Multiply, flowgraph.Divide, flowgraph.Modulo,
+		flowgraph.And, flowgraph.Or, flowgraph.Not, flowgraph.Shift,
+	} {
+		r.Register(strings.ToLower(c.String()), c, nil)
+	}
+	r.Register("sub", flowgraph.Subtract, nil)
+	r.Register("mul", flowgraph.Multiply, nil)
+	r.Register("div", flowgraph.Divide, nil)
+	r.Register("mod", flowgraph.Modulo, nil)
+	return r
+}
+
+// Register maps a hub name to a HubCode.  init, if not nil, is called
+// for each hub of that name to make its init arg.
+func (r *Registry) Register(name string, code flowgraph.HubCode, init func() interface{}) {
+	r.entries[name] = entry{code, init}
+}
+
+// RegisterRetriever maps a hub name to a Retrieve hub made with f
+func (r *Registry) RegisterRetriever(name string, f func() flowgraph.Retriever) {
+	r.Register(name, flowgraph.Retrieve, func() interface{} { return f() })
+}
+
+// RegisterTransformer maps a hub name to a hub with a Transformer made with f
+func (r *Registry) RegisterTransformer(name string, code flowgraph.HubCode, f func() flowgraph.Transformer) {
+	r.Register(name, code, func() interface{} { return f() })
+}
+
+// lookup finds a hub name, then the name without a _N suffix, then the
+// name without trailing digits
+func (r *Registry) lookup(name string) (entry, bool) {
+	if e, ok := r.entries[name]; ok {
+		return e, true
+	}
+	trimmed := strings.TrimRight(name, "0123456789")
+	if strings.HasSuffix(trimmed, "_") && len(trimmed) < len(name) {
+		if e, ok := r.entries[strings.TrimSuffix(trimmed, "_")]; ok {
+			return e, true
+		}
+	}
+	e, ok := r.entries[trimmed]
+	return e, ok
+}
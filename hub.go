@@ -2,6 +2,8 @@ package flowgraph
 
 import (
 	"github.com/vectaport/fgbase"
+
+	"fmt"
 )
 
 // Hub interface for flowgraph hubs that are connected by flowgraph pipes
@@ -10,7 +12,8 @@ type Hub interface {
 	// Name returns the hub name
 	Name() string
 
-	// SetName sets the hub name
+	// SetName sets the hub name, suffixed as by NewHub if another hub
+	// already has it
 	SetName(name string)
 
 	// Tracef for debug trace printing.  Uses atomic log mechanism.
@@ -28,6 +31,12 @@ type Hub interface {
 	// Result returns result pipe selected by string or int
 	Result(port interface{}) Pipe
 
+	// TrySource is Source returning an error instead of panicking
+	TrySource(port interface{}) (Pipe, error)
+
+	// TryResult is Result returning an error instead of panicking
+	TryResult(port interface{}) (Pipe, error)
+
 	// SetSource sets a pipe on a source port selected by string or int
 	SetSource(port interface{}, s Pipe) Hub
 
@@ -79,6 +88,23 @@ type Hub interface {
 	// HubCode returns code associated with hub.
 	HubCode() HubCode
 
+	// ErrorPolicy returns the error policy of the hub
+	ErrorPolicy() ErrorPolicy
+
+	// SetErrorPolicy sets the error policy of the hub.  ResultOnError needs
+	// a result port named ErrorPort, for the errors alone.
+	SetErrorPolicy(p ErrorPolicy) Hub
+
+	// SetParallelism replicates an AllOf hub n ways when run.  Each
+	// wavefront goes to the next copy round-robin, and the results come
+	// back out in the order the wavefronts came in, so the Transformer
+	// must be safe to call from more than one goroutine at once.  Stats
+	// reports the copies together under the name of the hub.
+	SetParallelism(n int) Hub
+
+	// Parallelism returns the number of copies of the hub when run
+	Parallelism() int
+
 	// Empty returns true if the underlying implementation is nil
 	Empty() bool
 
@@ -116,53 +142,84 @@ func (h *hub) Name() string {
 	return h.base.Name
 }
 
-// SetName returns the hub name
+// SetName sets the hub name, suffixed if another hub already has it
 func (h *hub) SetName(name string) {
+	if h.fg.nameToHub[h.base.Name] == h.base.Owner {
+		delete(h.fg.nameToHub, h.base.Name)
+	}
+	name = h.fg.uniqueHubName(name)
 	h.base.Name = name
+	h.fg.nameToHub[name] = h.base.Owner.(Hub)
 }
 
 // Source returns source pipe selected by int or string
 func (h *hub) Source(port interface{}) Pipe {
-	var i int
-	var ok bool
+	i, err := h.sourcePort(port)
+	if err != nil {
+		h.Panicf("%v\n", err)
+	}
+	return &pipe{h.base.Src(i), h.fg}
+}
+
+// TrySource is Source returning an error instead of panicking
+func (h *hub) TrySource(port interface{}) (Pipe, error) {
+	i, err := h.sourcePort(port)
+	if err != nil {
+		return nil, err
+	}
+	return &pipe{h.base.Src(i), h.fg}, nil
+}
+
+// sourcePort returns the index of a source port selected by int or string
+func (h *hub) sourcePort(port interface{}) (int, error) {
 	switch v := port.(type) {
 	case string:
-		i = h.SourceIndex(v)
-		ok = i >= 0
+		if i, ok := h.base.FindSrcIndex(v); ok {
+			return i, nil
+		}
 	case int:
-		ok = v >= 0 && v < h.NumSource()
-		i = v
+		if v >= 0 && v < h.NumSource() {
+			return v, nil
+		}
 	default:
-		h.Panicf("Need string or int to select port on Hub %s to get source pipe\n", h.Name())
+		return 0, fmt.Errorf("Need string or int to select port on Hub %s to get source pipe", h.Name())
 	}
+	return 0, fmt.Errorf("Source port %v not found on Hub %v", port, h.Name())
+}
 
-	if !ok {
-		h.Panicf("Source port %v not found on Hub %v\n", port, h.Name())
+// Result returns result pipe selected by int or string
+func (h *hub) Result(port interface{}) Pipe {
+	i, err := h.resultPort(port)
+	if err != nil {
+		h.Panicf("%v\n", err)
 	}
+	return &pipe{h.base.Dst(i), h.fg}
+}
 
-	return &pipe{h.base.Src(i), h.fg}
+// TryResult is Result returning an error instead of panicking
+func (h *hub) TryResult(port interface{}) (Pipe, error) {
+	i, err := h.resultPort(port)
+	if err != nil {
+		return nil, err
+	}
+	return &pipe{h.base.Dst(i), h.fg}, nil
 }
 
-// Result returns result pipe selected by int or string
-func (h *hub) Result(port interface{}) Pipe {
-	var i int
-	var ok bool
+// resultPort returns the index of a result port selected by int or string
+func (h *hub) resultPort(port interface{}) (int, error) {
 	switch v := port.(type) {
 	case string:
-		i = h.ResultIndex(v)
-		ok = i >= 0
+		if i, ok := h.base.FindDstIndex(v); ok {
+			return i, nil
+		}
 	case int:
-		ok = v >= 0 && v < h.NumResult()
-		i = v
+		if v >= 0 && v < h.NumResult() {
+			return v, nil
+		}
 	default:
-		h.Panicf("Need string or int to select port on Hub %s to get result pipe\n", h.Name())
+		return 0, fmt.Errorf("Need string or int to select port on Hub %s to get result pipe", h.Name())
 	}
-
-	if !ok {
-		h.Panicf("Result port %v not found on Hub %v\n", port, h.Name())
-	}
-
-	return &pipe{h.base.Dst(i), h.fg}
+	return 0, fmt.Errorf("Result port %v not found on Hub %v", port, h.Name())
 }
 
 // SetSource sets a pipe on a source port selected by string or int
@@ -187,6 +244,7 @@ func (h *hub) SetSource(port interface{}, s Pipe) Hub {
 	}
 
 	if s != nil && s.Base() != nil {
+		h.fg.checkSource(h, i, s)
 		e := *s.Base().(*fgbase.Edge)
 		h.base.SrcSet(i, &e)
 	}
@@ -215,6 +273,7 @@ func (h *hub) SetResult(port interface{}, s Pipe) Hub {
 		h.Panicf("Result port %v not found on Hub %v\n", port, h.Name())
 	}
 
+	h.fg.checkResult(h, i, s)
 	e := *s.Base().(*fgbase.Edge)
 
 	h.base.DstSet(i, &e)
@@ -225,6 +284,7 @@ func (h *hub) SetResult(port interface{}, s Pipe) Hub {
 func (h *hub) AddSources(s ...Pipe) Hub {
 	for _, sv := range s {
 		checkInternalPipe(h.Flowgraph(), sv)
+		h.fg.checkSource(h, h.NumSource(), sv)
 		h.Base().(*fgbase.Node).SrcAppend(sv.Base().(*fgbase.Edge))
 	}
 	return h
@@ -234,6 +294,7 @@ func (h *hub) AddSources(s ...Pipe) Hub {
 func (h *hub) AddResults(s ...Pipe) Hub {
 	for _, sv := range s {
 		checkInternalPipe(h.Flowgraph(), sv)
+		h.fg.checkResult(h, h.NumResult(), sv)
 		h.Base().(*fgbase.Node).DstAppend(sv.Base().(*fgbase.Edge))
 	}
 	return h
@@ -366,6 +427,21 @@ func (h *hub) HubCode() HubCode {
 	return h.code
 }
 
+// ErrorPolicy returns the error policy of the hub
+func (h *hub) ErrorPolicy() ErrorPolicy {
+	return h.fg.errorPolicy(h.base)
+}
+
+// SetErrorPolicy sets the error policy of the hub.  ResultOnError needs a
+// result port named ErrorPort.
+func (h *hub) SetErrorPolicy(p ErrorPolicy) Hub {
+	if _, ok := h.base.FindDstIndex(ErrorPort); !ok && p.Code == ResultOnError {
+		h.Panicf("ResultOnError without a result port named %q\n", ErrorPort)
+	}
+	h.fg.policies[h.base] = p
+	return h
+}
+
 // Empty returns true if the underlying implementation is nil
 func (h *hub) Empty() bool {
 	return h.base == nil
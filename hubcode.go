@@ -25,13 +25,13 @@ const (
 	Array    //	[]interface{}	0,1	produce array of values then EOS
 	Constant //	interface{}	0,1	produce constant values forever
 	Pass     //	nil		1,1	pass value
-	Split    //	nil		1,n     split slice into values
+	Split    //	[interface{}]	1,n     split slice into values, short slices padded by init
 	Join     //	nil		n,1     join values into slice
 	Sink     //	[Sinker]	1,0	consume values forever
 
 	Graph  // 	nil		n,m     hub with general purpose internals
 	While  // 	nil		n,n	hub with while loop around internals
-	During // 	nil		n,n	hub with while loop with continuous results
+	During // 	nil		n,n	hub with while loop with results of every iteration
 
 	Add      //	[Transformer]	2,1	add numbers, concat strings
 	Subtract //	[Transformer]	2,1	subtract numbers
@@ -68,7 +68,7 @@ func (c HubCode) String() string {
 
 		"Graph",
 		"While",
-		"During	",
+		"During",
 
 		"Add",
 		"Subtract",
@@ -83,10 +83,12 @@ func (c HubCode) String() string {
 }
 
 // ShiftCode is the subcode for the "Shift" HubCode, provided as the init arg to NewHub.
+// The first source is shifted left by the second, or right if the second is negative,
+// within the width of the first source's integer type.
 type ShiftCode int
 
 const (
-	Arith ShiftCode = iota
-	Barrel
-	Signed
+	Arith  ShiftCode = iota // shift in zeros
+	Barrel                  // rotate bits shifted out back in
+	Signed                  // extend the sign bit when shifting right
 )
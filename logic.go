@@ -0,0 +1,236 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"errors"
+	"fmt"
+	"math/bits"
+	"reflect"
+)
+
+// logicInit wraps the init arg of a logic HubCode, checked by initError.  A
+// Transformer overrides the built-in arithmetic, Shift otherwise takes a ShiftCode.
+func logicInit(fg *flowgraph, code HubCode, init interface{}) interface{} {
+	if t, ok := init.(Transformer); ok {
+		return &fgTransformer{fg, t}
+	}
+	if code == Shift && init == nil {
+		return Arith
+	}
+	return init
+}
+
+// logicFire gathers one value from each source and puts the result of
+// f, or of the overriding Transformer, on the single result.
+func logicFire(n *fgbase.Node, code HubCode, f func(a []interface{}) (interface{}, error)) error {
+	a := make([]interface{}, n.SrcCnt())
+	eofflag := false
+	for i := range a {
+		a[i] = n.Srcs[i].SrcGet()
+		if v, ok := a[i].(error); ok && errors.Is(v, fgbase.EOS) {
+			n.Srcs[i].Flow = false
+			eofflag = true
+		}
+	}
+	if eofflag {
+		n.Dsts[0].DstPut(EOS)
+		return EOS
+	}
+
+	if ft, ok := n.Aux.(*fgTransformer); ok {
+		var x []interface{}
+		if err := ft.fg.callUser(n, func() (err error) {
+			x, err = ft.t.Transform(&hub{n, ft.fg, code}, a)
+			return err
+		}); err != nil && !isEOS(err) {
+			return nil
+		}
+		if len(x) > 0 && x[0] != nil {
+			n.Dsts[0].DstPut(x[0])
+		}
+		return nil
+	}
+
+	x, err := f(a)
+	if err != nil {
+		n.Owner.(Hub).Flowgraph().(*flowgraph).hubError(n, err)
+		return nil
+	}
+	n.Dsts[0].DstPut(x)
+	return nil
+}
+
+func andFire(n *fgbase.Node) error {
+	return logicFire(n, And, func(a []interface{}) (interface{}, error) {
+		return bitwise(And, a[0], a[1],
+			func(x, y bool) bool { return x && y },
+			func(x, y uint64) uint64 { return x & y })
+	})
+}
+
+func orFire(n *fgbase.Node) error {
+	return logicFire(n, Or, func(a []interface{}) (interface{}, error) {
+		return bitwise(Or, a[0], a[1],
+			func(x, y bool) bool { return x || y },
+			func(x, y uint64) uint64 { return x | y })
+	})
+}
+
+func notFire(n *fgbase.Node) error {
+	return logicFire(n, Not, func(a []interface{}) (interface{}, error) {
+		if b, ok := a[0].(bool); ok {
+			return !b, nil
+		}
+		u, w, ok := intBits(a[0])
+		if !ok {
+			return nil, fmt.Errorf("Not given %T(%+v) instead of bool or integer", a[0], a[0])
+		}
+		return fromBits(^u&mask(w), a[0]), nil
+	})
+}
+
+func shiftFire(n *fgbase.Node) error {
+	sc, _ := n.Aux.(ShiftCode)
+	return logicFire(n, Shift, func(a []interface{}) (interface{}, error) {
+		u, w, ok := intBits(a[0])
+		if !ok {
+			return nil, fmt.Errorf("Shift given %T(%+v) instead of integer to shift", a[0], a[0])
+		}
+		k, ok := intValue(a[1])
+		if !ok {
+			return nil, fmt.Errorf("Shift given %T(%+v) instead of integer shift count", a[1], a[1])
+		}
+		return fromBits(shift(sc, u, w, k), a[0]), nil
+	})
+}
+
+// bitwise applies a bool or an integer op to two operands of the same type.
+func bitwise(code HubCode, a, b interface{}, bf func(x, y bool) bool, uf func(x, y uint64) uint64) (interface{}, error) {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return nil, fmt.Errorf("%s given mismatched %T(%+v) and %T(%+v)", code, a, a, b, b)
+	}
+	if ab, ok := a.(bool); ok {
+		return bf(ab, b.(bool)), nil
+	}
+	au, w, aok := intBits(a)
+	bu, _, bok := intBits(b)
+	if !aok || !bok {
+		return nil, fmt.Errorf("%s given %T(%+v) and %T(%+v) instead of bool or integers", code, a, a, b, b)
+	}
+	return fromBits(uf(au, bu)&mask(w), a), nil
+}
+
+// shift shifts the low w bits of u left by k (right if k is negative).
+// Arith fills with zeros, Barrel rotates, and Signed extends the sign bit
+// on a right shift.
+func shift(sc ShiftCode, u uint64, w uint, k int64) uint64 {
+	u &= mask(w)
+	if sc == Barrel {
+		k %= int64(w)
+		if k < 0 {
+			k += int64(w)
+		}
+		return (u<<uint(k) | u>>(w-uint(k))) & mask(w)
+	}
+	if k >= 0 {
+		if k >= int64(w) {
+			return 0
+		}
+		return (u << uint(k)) & mask(w)
+	}
+	k = -k
+	if sc == Signed {
+		s := int64(u<<(64-w)) >> (64 - w)
+		if k >= int64(w) {
+			k = int64(w) - 1
+		}
+		return uint64(s>>uint(k)) & mask(w)
+	}
+	if k >= int64(w) {
+		return 0
+	}
+	return u >> uint(k)
+}
+
+// mask returns a mask of the low w bits.
+func mask(w uint) uint64 {
+	if w >= 64 {
+		return ^uint64(0)
+	}
+	return 1<<w - 1
+}
+
+// intBits returns the bits and the width of any Go integer.
+func intBits(v interface{}) (u uint64, w uint, ok bool) {
+	switch x := v.(type) {
+	case int:
+		return uint64(x), bits.UintSize, true
+	case int8:
+		return uint64(x), 8, true
+	case int16:
+		return uint64(x), 16, true
+	case int32:
+		return uint64(x), 32, true
+	case int64:
+		return uint64(x), 64, true
+	case uint:
+		return uint64(x), bits.UintSize, true
+	case uint8:
+		return uint64(x), 8, true
+	case uint16:
+		return uint64(x), 16, true
+	case uint32:
+		return uint64(x), 32, true
+	case uint64:
+		return x, 64, true
+	case uintptr:
+		return uint64(x), bits.UintSize, true
+	}
+	return 0, 0, false
+}
+
+// intValue returns the value of any Go integer as an int64.
+func intValue(v interface{}) (int64, bool) {
+	u, w, ok := intBits(v)
+	if !ok {
+		return 0, false
+	}
+	switch v.(type) {
+	case int, int8, int16, int32, int64:
+		return int64(u), true
+	}
+	if w == 64 && u > 1<<63-1 {
+		return 0, false
+	}
+	return int64(u), true
+}
+
+// fromBits converts bits back to the integer type of like.
+func fromBits(u uint64, like interface{}) interface{} {
+	switch like.(type) {
+	case int:
+		return int(u)
+	case int8:
+		return int8(u)
+	case int16:
+		return int16(u)
+	case int32:
+		return int32(u)
+	case int64:
+		return int64(u)
+	case uint:
+		return uint(u)
+	case uint8:
+		return uint8(u)
+	case uint16:
+		return uint16(u)
+	case uint32:
+		return uint32(u)
+	case uint64:
+		return u
+	case uintptr:
+		return uintptr(u)
+	}
+	return nil
+}
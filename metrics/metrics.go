@@ -0,0 +1,129 @@
+// Package metrics serves the runtime metrics of a running Flowgraph over
+// HTTP in the Prometheus text exposition format.
+//
+//	http.Handle("/metrics", metrics.Handler(fg))
+//	go http.ListenAndServe(":2112", nil)
+//	fg.Run()
+//
+// Every metric is labeled with the flowgraph title, and the dotted name of
+// the hub or pipe.  Hub metrics are also labeled with the HubCode.
+package metrics
+
+import (
+	"github.com/vectaport/flowgraph"
+
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the type of the text served by Handler
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns an http.Handler that serves the metrics of fg from
+// Flowgraph.Stats, during or after Run
+func Handler(fg flowgraph.Flowgraph) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		Write(w, fg.Title(), fg.Stats())
+	})
+}
+
+// Write writes stats in the Prometheus text exposition format, labeled
+// with the title of their flowgraph
+func Write(w io.Writer, title string, stats flowgraph.Stats) error {
+	e := &exposer{w: w}
+
+	e.help("flowgraph_hub_fires_total", "counter", "Times the hub fired.")
+	for _, h := range stats.Hubs {
+		e.sample("flowgraph_hub_fires_total", hubLabels(title, h), float64(h.Fires))
+	}
+
+	e.help("flowgraph_hub_user_seconds", "histogram", "Time in the Transform, Retrieve, or Transmit of the hub.")
+	for _, h := range stats.Hubs {
+		labels := hubLabels(title, h)
+		var n int64
+		for i, c := range h.UserHist {
+			n += c
+			le := "+Inf"
+			if i < len(flowgraph.StatsBuckets) {
+				le = formatFloat(flowgraph.StatsBuckets[i].Seconds())
+			}
+			e.sample("flowgraph_hub_user_seconds_bucket", append(labels, "le", le), float64(n))
+		}
+		e.sample("flowgraph_hub_user_seconds_sum", labels, h.UserTime.Seconds())
+		e.sample("flowgraph_hub_user_seconds_count", labels, float64(n))
+	}
+
+	e.help("flowgraph_hub_source_wait_seconds_total", "counter", "Time the hub waited for source values.")
+	for _, h := range stats.Hubs {
+		e.sample("flowgraph_hub_source_wait_seconds_total", hubLabels(title, h), h.SourceWait.Seconds())
+	}
+
+	e.help("flowgraph_hub_result_wait_seconds_total", "counter", "Time the hub waited for room on result pipes.")
+	for _, h := range stats.Hubs {
+		e.sample("flowgraph_hub_result_wait_seconds_total", hubLabels(title, h), h.ResultWait.Seconds())
+	}
+
+	e.help("flowgraph_pipe_tokens_total", "counter", "Values put on the pipe.")
+	for _, p := range stats.Pipes {
+		e.sample("flowgraph_pipe_tokens_total", pipeLabels(title, p), float64(p.Tokens))
+	}
+
+	e.help("flowgraph_pipe_occupancy", "gauge", "Average number of values waiting on the pipe.")
+	for _, p := range stats.Pipes {
+		e.sample("flowgraph_pipe_occupancy", pipeLabels(title, p), p.Occupancy)
+	}
+
+	return e.err
+}
+
+// exposer writes metrics, keeping the first error
+type exposer struct {
+	w   io.Writer
+	err error
+}
+
+// help writes the HELP and TYPE lines of a metric
+func (e *exposer) help(name, typ, help string) {
+	e.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample, with labels given as name, value pairs
+func (e *exposer) sample(name string, labels []string, v float64) {
+	var b strings.Builder
+	for i := 0; i < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", labels[i], escape(labels[i+1]))
+	}
+	e.printf("%s{%s} %s\n", name, b.String(), formatFloat(v))
+}
+
+func (e *exposer) printf(format string, v ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, v...)
+}
+
+func hubLabels(title string, h flowgraph.HubStats) []string {
+	return []string{"flowgraph", title, "hub", h.Name, "code", h.Code.String()}
+}
+
+func pipeLabels(title string, p flowgraph.PipeStats) []string {
+	return []string{"flowgraph", title, "pipe", p.Name}
+}
+
+// escape escapes a label value
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// formatFloat formats a sample value the way Prometheus does
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
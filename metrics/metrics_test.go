@@ -0,0 +1,140 @@
+package metrics_test
+
+import (
+	"github.com/vectaport/fgbase"
+	"github.com/vectaport/flowgraph"
+	"github.com/vectaport/flowgraph/metrics"
+
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* TestHandler Flowgraph HDL *
+
+two()(a)
+double(a)(x)
+sink(x)()
+
+*/
+
+func TestHandler(t *testing.T) {
+	fmt.Printf("BEGIN:  TestHandler\n")
+	o := flowgraph.DefaultOptions()
+	o.RunTime = -1
+	o.TraceLevel = fgbase.Q
+	fg := flowgraph.NewWithOptions("TestHandler", o)
+
+	a, x := fg.NewPipe("a"), fg.NewPipe("x")
+	fg.NewHub("two", flowgraph.Constant, 2).
+		ConnectResults(a)
+	double := flowgraph.Func1[int, int](func(h flowgraph.Hub, a int) (int, error) {
+		return a * 2, nil
+	})
+	fg.NewHub("double", flowgraph.AllOf, double).
+		ConnectSources(a).ConnectResults(x)
+	fg.NewHub("sink", flowgraph.Sink, nil).
+		ConnectSources(x)
+
+	srv := httptest.NewServer(metrics.Handler(fg))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error)
+	go func() { ran <- fg.RunContext(ctx) }()
+
+	// scrape twice while running, the fire count rising in between
+	time.Sleep(time.Second / 20)
+	body := scrape(t, srv)
+	first := sample(t, body, `flowgraph_hub_fires_total{flowgraph="TestHandler",hub="double",code="AllOf"}`)
+	time.Sleep(time.Second / 20)
+	second := sample(t, scrape(t, srv), `flowgraph_hub_fires_total{flowgraph="TestHandler",hub="double",code="AllOf"}`)
+	cancel()
+	<-ran
+
+	if first == 0 || second <= first {
+		t.Fatalf("ERROR Handler fire counts while running %v then %v, expected non-zero and rising\n", first, second)
+	}
+	if n := sample(t, body, `flowgraph_pipe_tokens_total{flowgraph="TestHandler",pipe="x"}`); n == 0 {
+		t.Fatalf("ERROR Handler served no tokens on x while running\n")
+	}
+	for _, want := range []string{
+		"# TYPE flowgraph_hub_fires_total counter\n",
+		`flowgraph_hub_user_seconds_bucket{flowgraph="TestHandler",hub="double",code="AllOf",le="1e-06"} `,
+		`flowgraph_hub_user_seconds_count{flowgraph="TestHandler",hub="double",code="AllOf"} `,
+		"# TYPE flowgraph_pipe_occupancy gauge\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("ERROR Handler served no %q in\n%s\n", want, body)
+		}
+	}
+	fmt.Printf("END:    TestHandler\n")
+}
+
+// scrape gets the metrics served by srv
+func scrape(t *testing.T, srv *httptest.Server) string {
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("ERROR Handler get:  %v\n", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != metrics.ContentType {
+		t.Fatalf("ERROR Handler Content-Type %q\n", ct)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ERROR Handler read:  %v\n", err)
+	}
+	return string(b)
+}
+
+// sample returns the value of the sample with the given name and labels
+func sample(t *testing.T, body, name string) float64 {
+	for _, line := range strings.Split(body, "\n") {
+		if v, ok := strings.CutPrefix(line, name+" "); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				t.Fatalf("ERROR Handler sample %s:  %v\n", name, err)
+			}
+			return f
+		}
+	}
+	t.Fatalf("ERROR Handler served no %s in\n%s\n", name, body)
+	return 0
+}
+
+func TestWrite(t *testing.T) {
+	fmt.Printf("BEGIN:  TestWrite\n")
+	stats := flowgraph.Stats{
+		Hubs: []flowgraph.HubStats{{
+			Name:     `a"b`,
+			Code:     flowgraph.AllOf,
+			Fires:    3,
+			UserTime: 2 * time.Millisecond,
+			UserHist: []int64{1, 0, 0, 2, 0, 0, 0, 0},
+		}},
+		Pipes: []flowgraph.PipeStats{{Name: "p", Tokens: 3, Occupancy: 0.5}},
+	}
+	var b strings.Builder
+	if err := metrics.Write(&b, "TestWrite", stats); err != nil {
+		t.Fatalf("ERROR Write:  %v\n", err)
+	}
+	for _, want := range []string{
+		`flowgraph_hub_fires_total{flowgraph="TestWrite",hub="a\"b",code="AllOf"} 3` + "\n",
+		`flowgraph_hub_user_seconds_bucket{flowgraph="TestWrite",hub="a\"b",code="AllOf",le="0.0001"} 1` + "\n",
+		`flowgraph_hub_user_seconds_bucket{flowgraph="TestWrite",hub="a\"b",code="AllOf",le="0.001"} 3` + "\n",
+		`flowgraph_hub_user_seconds_bucket{flowgraph="TestWrite",hub="a\"b",code="AllOf",le="+Inf"} 3` + "\n",
+		`flowgraph_hub_user_seconds_sum{flowgraph="TestWrite",hub="a\"b",code="AllOf"} 0.002` + "\n",
+		`flowgraph_pipe_occupancy{flowgraph="TestWrite",pipe="p"} 0.5` + "\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Fatalf("ERROR Write wrote no %q in\n%s\n", want, b.String())
+		}
+	}
+	fmt.Printf("END:    TestWrite\n")
+}
@@ -0,0 +1,103 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"io"
+	"time"
+)
+
+// Options configure a flowgraph in place of the fgbase package globals.
+// Each flowgraph keeps its own, so flowgraphs with different Options can
+// run at once.  A zero ChannelSize, TraceWriter, or RunTime takes its
+// default from DefaultOptions.  fgbase itself still traces by its own
+// package globals.
+type Options struct {
+	TraceLevel  fgbase.TraceLevelType // level of trace output
+	TraceWriter io.Writer             // where trace output goes
+	ChannelSize int                   // default capacity of new pipes, see Pipe.SetCapacity
+	RunTime     time.Duration         // how long Run runs, negative for until every hub exits
+	DotOutput   bool                  // write a dot graph instead of running
+	FlatDot     bool                  // flatten GraphHubs in dot output
+
+	StallTimeout time.Duration // report a stall after this long with no hub firing, 0 for never
+	FailOnStall  bool          // end the run with a StallError on a stall
+
+	// TraceEvents if not nil is written every hub firing of a run as it
+	// happens, as Chrome Trace Event JSON for chrome://tracing or Perfetto
+	TraceEvents io.Writer
+
+	// VCD if not nil is written the traffic on every pipe of a run as a
+	// Value Change Dump, for GTKWave
+	VCD        io.Writer
+	VCDFirings bool // time the VCD by count of hub firings instead of nanoseconds
+
+	Executor  Executor // how hubs are fired, Goroutines by default
+	MaxCycles int64    // Clocked runs stop after this many cycles, Sequential after this many firings, 0 for no limit
+
+	Schedule    SchedulePolicy // how the Sequential executor picks the next hub to fire
+	Seed        int64          // seed of the Random schedule
+	HubPriority func(Hub) int  // priority of each hub for the Priority schedule, higher first
+
+	Workers int // goroutines of the WorkerPool executor, GOMAXPROCS if 0
+}
+
+// DefaultOptions returns the Options given by the fgbase package globals
+// and the flags read by ParseFlags
+func DefaultOptions() Options {
+	return Options{
+		TraceLevel:  fgbase.TraceLevel,
+		TraceWriter: fgbase.StdoutLog.Writer(),
+		ChannelSize: fgbase.ChannelSize,
+		RunTime:     fgbase.RunTime,
+		DotOutput:   fgbase.DotOutput,
+		FlatDot:     flatDot,
+	}
+}
+
+// NewWithOptions returns a titled flowgraph configured by o
+func NewWithOptions(title string, o Options) Flowgraph {
+	fg := New(title)
+	fg.SetOptions(o)
+	return fg
+}
+
+// Options returns the options of this flowgraph, DefaultOptions if never set
+func (fg *flowgraph) Options() Options {
+	if fg.opts == nil {
+		return DefaultOptions()
+	}
+	return *fg.opts
+}
+
+// SetOptions configures this flowgraph and every GraphHub inside it
+func (fg *flowgraph) SetOptions(o Options) {
+	fg.opts = &o
+	for _, h := range fg.hubs {
+		if gh, ok := h.(*graphhub); ok {
+			gh.SetOptions(o)
+		}
+	}
+}
+
+// runOptions returns the options of a run of this flowgraph, with the
+// defaults of DefaultOptions in place of zero fields
+func (fg *flowgraph) runOptions() Options {
+	o := fg.Options()
+	d := DefaultOptions()
+	if o.TraceWriter == nil {
+		o.TraceWriter = d.TraceWriter
+	}
+	if o.ChannelSize == 0 {
+		o.ChannelSize = d.ChannelSize
+	}
+	if o.RunTime == 0 {
+		o.RunTime = d.RunTime
+	}
+	return o
+}
+
+// channelSize returns the capacity for new pipes
+func (fg *flowgraph) channelSize() int {
+	return fg.clampCapacity(fg.runOptions().ChannelSize)
+}
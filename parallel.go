@@ -0,0 +1,260 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+)
+
+// SetParallelism replicates an AllOf hub n ways when run
+func (h *hub) SetParallelism(n int) Hub {
+	if h.code != AllOf {
+		h.Panicf("SetParallelism of a %s hub, only AllOf can be replicated\n", h.code)
+	}
+	if n < 1 {
+		h.Panicf("SetParallelism of %d, must be at least 1\n", n)
+	}
+	if h.fg.parallel == nil {
+		h.fg.parallel = make(map[*fgbase.Node]int)
+	}
+	h.fg.parallel[h.base] = n
+	return h
+}
+
+// Parallelism returns the number of copies of the hub when run
+func (h *hub) Parallelism() int {
+	if n, ok := h.fg.parallel[h.base]; ok {
+		return n
+	}
+	return 1
+}
+
+// SetParallelism panics, since a GraphHub cannot be replicated
+func (gh *graphhub) SetParallelism(n int) Hub {
+	gh.Panicf("SetParallelism of a GraphHub, only AllOf can be replicated\n")
+	return gh
+}
+
+// Parallelism returns 1 for a GraphHub
+func (gh *graphhub) Parallelism() int {
+	return 1
+}
+
+// noResult stands in for a result not given by a copy of a replicated
+// hub, so results can be put back in order
+type noResult struct{}
+
+// roundRobin is the copy of a replicated hub the distributor hands the
+// next wavefront to, or the reorder node takes the next results from
+type roundRobin struct {
+	next int
+}
+
+// replicate returns the nodes to run for a flattened flowgraph, with the
+// node of each replicated hub replaced by the nodes that stand in for it,
+// and a func that puts the nodes of those hubs back once the run is over
+func (fg *flowgraph) replicate(nodes []*fgbase.Node) ([]*fgbase.Node, func()) {
+	var run []*fgbase.Node
+	var restores []func()
+	for _, n := range nodes {
+		h, ok := n.Owner.(Hub)
+		if !ok {
+			run = append(run, n)
+			continue
+		}
+		hfg := h.Flowgraph().(*flowgraph)
+		delete(hfg.replicas, n)
+		np := hfg.parallel[n]
+		if np <= 1 {
+			run = append(run, n)
+			continue
+		}
+		reps, restore := hfg.replicateNode(n, np)
+		if hfg.replicas == nil {
+			hfg.replicas = make(map[*fgbase.Node][]*fgbase.Node)
+		}
+		hfg.replicas[n] = reps
+		run = append(run, reps...)
+		restores = append(restores, restore)
+	}
+	return run, func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+}
+
+// replicateNode returns the nodes that stand in for the node of a hub
+// replicated np ways: a distributor that takes the sources of the hub and
+// hands each wavefront to the next of the copies round-robin, the copies,
+// and a reorder node that takes the results from the copies in the same
+// order and puts them on the results of the hub, so downstream sees them
+// in the order they came in.  A copy with results waiting holds up only
+// itself and the distributor, so backpressure reaches back to the sources
+// as for one copy.  The node of the hub is only taken off its pipes, and
+// the func returned puts it back.
+func (fg *flowgraph) replicateNode(n *fgbase.Node, np int) ([]*fgbase.Node, func()) {
+	ns, nr := n.SrcCnt(), n.DstCnt()
+	if ns == 0 || nr == 0 {
+		n.Panicf("SetParallelism of a hub without both sources and results\n")
+	}
+
+	dist := fgbase.MakeNode(n.Name, make([]*fgbase.Edge, ns), make([]*fgbase.Edge, np*ns), distRdy, distFire)
+	dist.Owner, dist.Aux = n.Owner, &roundRobin{}
+	for i, e := range n.Srcs {
+		c := *e
+		e.Disconnect(n)
+		dist.SrcSet(i, &c)
+	}
+
+	reorder := fgbase.MakeNode(n.Name, make([]*fgbase.Edge, np*nr), make([]*fgbase.Edge, nr), reorderRdy, reorderFire)
+	reorder.Owner, reorder.Aux = n.Owner, &roundRobin{}
+	for i, e := range n.Dsts {
+		c := *e
+		e.Disconnect(n)
+		reorder.DstSet(i, &c)
+	}
+
+	copies := make([]*fgbase.Node, np)
+	for k := range copies {
+		c := fgbase.MakeNode(n.Name, make([]*fgbase.Edge, ns), make([]*fgbase.Edge, nr), n.RdyFunc, replicaFire(n.FireFunc))
+		c.Owner, c.Aux = n.Owner, n.Aux
+		c.SetSrcNames(n.SrcNames()...)
+		c.SetDstNames(n.DstNames()...)
+		for i := 0; i < ns; i++ {
+			fg.connectNodes(&dist, k*ns+i, &c, i)
+		}
+		for i := 0; i < nr; i++ {
+			fg.connectNodes(&c, i, &reorder, k*nr+i)
+		}
+		if p, ok := fg.policies[n]; ok {
+			fg.policies[&c] = p
+		}
+		copies[k] = &c
+	}
+
+	nodes := append([]*fgbase.Node{&dist}, copies...)
+	nodes = append(nodes, &reorder)
+	return nodes, func() {
+		for i, e := range dist.Srcs {
+			e.Disconnect(&dist)
+			n.SrcSet(i, n.Srcs[i])
+		}
+		for i, e := range reorder.Dsts {
+			e.Disconnect(&reorder)
+			n.DstSet(i, n.Dsts[i])
+		}
+		for _, c := range copies {
+			delete(fg.policies, c)
+		}
+	}
+}
+
+// runKey returns the key of a pipe in the last run, which for a result of
+// a replicated hub is on the reorder node that stood in for it
+func runKey(k pipeKey) pipeKey {
+	if h, ok := k.n.Owner.(Hub); ok {
+		if reps := h.Flowgraph().(*flowgraph).replicas[k.n]; reps != nil {
+			return pipeKey{reps[len(reps)-1], k.i}
+		}
+	}
+	return k
+}
+
+// connectNodes connects result i of node u to source j of node d with a
+// new pipe
+func (fg *flowgraph) connectNodes(u *fgbase.Node, i int, d *fgbase.Node, j int) {
+	e := fgbase.MakeEdge("", nil)
+	e.RdyCnt = fg.channelSize()
+	ue, de := e, e
+	u.DstSet(i, &ue)
+	d.SrcSet(j, &de)
+}
+
+// distRdy is ready when every source has a value and the next copy has
+// room for it, or at EOS
+func distRdy(n *fgbase.Node) bool {
+	eos := false
+	for _, e := range n.Srcs {
+		if !e.SrcRdy(n) {
+			return false
+		}
+		eos = eos || isEOS(e.Val)
+	}
+	if eos {
+		return true
+	}
+	ns := n.SrcCnt()
+	k := n.Aux.(*roundRobin).next
+	for i := 0; i < ns; i++ {
+		if !n.Dsts[k*ns+i].DstRdy(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// distFire hands a wavefront to the next copy, or EOS to every copy
+func distFire(n *fgbase.Node) error {
+	ns := n.SrcCnt()
+	st := n.Aux.(*roundRobin)
+	v := make([]interface{}, ns)
+	for i, e := range n.Srcs {
+		v[i] = e.SrcGet()
+		if isEOS(v[i]) {
+			return EOS
+		}
+	}
+	for i := range v {
+		n.Dsts[st.next*ns+i].DstPut(v[i])
+	}
+	st.next = (st.next + 1) % (n.DstCnt() / ns)
+	return nil
+}
+
+// replicaFire wraps the fire func of a copy of a replicated hub so that
+// every firing puts a value on every result
+func replicaFire(fire fgbase.NodeFire) fgbase.NodeFire {
+	return func(n *fgbase.Node) error {
+		err := fire(n)
+		for _, e := range n.Dsts {
+			if e.Val == nil {
+				e.DstPut(noResult{})
+			}
+		}
+		return err
+	}
+}
+
+// reorderRdy is ready when the copy next in order has results and there
+// is room for them
+func reorderRdy(n *fgbase.Node) bool {
+	nr := n.DstCnt()
+	k := n.Aux.(*roundRobin).next
+	for i := 0; i < nr; i++ {
+		if !n.Srcs[k*nr+i].SrcRdy(n) || !n.Dsts[i].DstRdy(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// reorderFire puts the results of the copy next in order on the results
+// of the hub
+func reorderFire(n *fgbase.Node) error {
+	nr := n.DstCnt()
+	st := n.Aux.(*roundRobin)
+	eos := false
+	for i := 0; i < nr; i++ {
+		v := n.Srcs[st.next*nr+i].SrcGet()
+		switch {
+		case isEOS(v):
+			eos = true
+		case v != noResult{}:
+			n.Dsts[i].DstPut(v)
+		}
+	}
+	st.next = (st.next + 1) % (n.SrcCnt() / nr)
+	if eos {
+		return EOS
+	}
+	return nil
+}
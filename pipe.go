@@ -2,6 +2,8 @@ package flowgraph
 
 import (
 	"github.com/vectaport/fgbase"
+
+	"fmt"
 )
 
 import ()
@@ -16,7 +18,8 @@ type Pipe interface {
 	// Name returns the pipe name
 	Name() string
 
-	// SetName sets the pipe name
+	// SetName sets the pipe name, suffixed as by NewPipe if another pipe
+	// already has it
 	SetName(name string)
 
 	// Upstream returns upstream hub by index
@@ -40,6 +43,15 @@ type Pipe interface {
 	// Sink sets a pipe to be a sink
 	Sink() Pipe
 
+	// SetCapacity sets how many values the pipe holds before its upstream
+	// hubs wait, in place of the default from Options.ChannelSize.  With
+	// the Goroutines executor it holds no more than fgbase.ChannelSize, the
+	// size of the channels fgbase makes.
+	SetCapacity(n int) Pipe
+
+	// Capacity returns how many values the pipe holds
+	Capacity() int
+
 	// IsConst returns true if pipe is a constant
 	IsConst() bool
 
@@ -70,8 +82,15 @@ func (s *pipe) Name() string {
 	return s.base.Name
 }
 
-// SetName sets the pipe name
+// SetName sets the pipe name, suffixed if another pipe already has it
 func (s *pipe) SetName(name string) {
+	if p := s.fg.nameToPipe[s.base.Name]; p != nil && p.Same(s) {
+		delete(s.fg.nameToPipe, s.base.Name)
+	}
+	if name != "" {
+		name = s.fg.uniquePipeName(name)
+		s.fg.nameToPipe[name] = s
+	}
 	s.base.SetName(name)
 }
 
@@ -119,6 +138,31 @@ func (s *pipe) Sink() Pipe {
 	return s
 }
 
+// SetCapacity sets how many values the pipe holds before its upstream
+// hubs wait
+func (s *pipe) SetCapacity(n int) Pipe {
+	if n < 1 {
+		panic(fmt.Sprintf("Pipe %q given capacity %d, needs at least 1", s.Name(), n))
+	}
+	s.fg.setCapacity(s, n)
+	n = s.fg.clampCapacity(n)
+	s.base.RdyCnt = n
+	for i := 0; i < s.base.SrcCnt(); i++ {
+		dsts := s.base.SrcNode(i).Dsts
+		for j := range dsts {
+			if dsts[j] != nil && dsts[j].Same(s.base) {
+				dsts[j].RdyCnt = n
+			}
+		}
+	}
+	return s
+}
+
+// Capacity returns how many values the pipe holds
+func (s *pipe) Capacity() int {
+	return s.fg.capacity(s.base)
+}
+
 // IsConst returns true if pipe is a constant
 func (s *pipe) IsConst() bool {
 	return s.Base().(*fgbase.Edge).IsConst()
@@ -156,3 +200,40 @@ func (s *pipe) Flowgraph() Flowgraph {
 func (s *pipe) Base() interface{} {
 	return s.base
 }
+
+// pipeCap is the capacity given a pipe by SetCapacity
+type pipeCap struct {
+	p Pipe
+	n int
+}
+
+// setCapacity records the capacity of a pipe
+func (fg *flowgraph) setCapacity(p Pipe, n int) {
+	for i := range fg.pcaps {
+		if fg.pcaps[i].p.Same(p) {
+			fg.pcaps[i].n = n
+			return
+		}
+	}
+	fg.pcaps = append(fg.pcaps, pipeCap{p, n})
+}
+
+// capacity returns the capacity of the pipe of an edge, the default if
+// never set
+func (fg *flowgraph) capacity(e *fgbase.Edge) int {
+	for _, pc := range fg.pcaps {
+		if pc.p.Base().(*fgbase.Edge).Same(e) {
+			return fg.clampCapacity(pc.n)
+		}
+	}
+	return fg.channelSize()
+}
+
+// clampCapacity limits a capacity to the size of the channels fgbase
+// makes, which is all a pipe holds when hubs run as goroutines
+func (fg *flowgraph) clampCapacity(n int) int {
+	if fg.Options().Executor == Goroutines && n > fgbase.ChannelSize {
+		return fgbase.ChannelSize
+	}
+	return n
+}
@@ -0,0 +1,182 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"runtime"
+	"sync"
+	"time"
+)
+
+// pool fires the nodes of a run on a fixed number of worker goroutines.
+// A node is queued to be checked for ready when it might have become so,
+// that is when a value is put on one of its sources, room is given back
+// on one of its results, or it has just fired.  A node is never checked
+// or fired by two workers at once.
+type pool struct {
+	s       *scheduler
+	mu      sync.Mutex // guards the queues and edges of the scheduler, and the rest
+	state   map[*fgbase.Node]int
+	checked map[*fgbase.Node]bool
+	queue   chan *fgbase.Node
+	busy    int           // nodes queued or running
+	idle    chan struct{} // signalled when busy drops to zero
+}
+
+// states of a node in a pool
+const (
+	poolIdle = iota
+	poolQueued
+	poolRunning
+	poolRerun // running, and to be queued again once done
+)
+
+// runPool runs the nodes on a pool of workers, GOMAXPROCS of them if
+// workers is 0.  The run ends when every node has exited, no node is
+// ready, or after Options.RunTime.
+func (s *scheduler) runPool(workers int) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	p := &pool{
+		s:       s,
+		state:   make(map[*fgbase.Node]int),
+		checked: make(map[*fgbase.Node]bool),
+		queue:   make(chan *fgbase.Node, len(s.nodes)),
+		idle:    make(chan struct{}, 1),
+	}
+	p.notifyAll()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			p.work(stop)
+		}()
+	}
+	defer wg.Wait()
+	defer close(stop)
+
+	var expired <-chan time.Time
+	if !s.until.IsZero() {
+		t := time.NewTimer(time.Until(s.until))
+		defer t.Stop()
+		expired = t.C
+	}
+	for {
+		select {
+		case <-p.idle:
+		case <-expired:
+			return
+		}
+		p.mu.Lock()
+		busy, done := p.busy, s.done()
+		p.mu.Unlock()
+		if busy > 0 {
+			continue
+		}
+		if done || !s.idle() {
+			return
+		}
+		p.notifyAll()
+	}
+}
+
+// work checks and fires the nodes queued until stopped
+func (p *pool) work(stop <-chan struct{}) {
+	s := p.s
+	for {
+		var n *fgbase.Node
+		select {
+		case <-stop:
+			return
+		case n = <-p.queue:
+		}
+
+		p.mu.Lock()
+		p.state[n] = poolRunning
+		rdy := false
+		if !s.exited[n] {
+			s.load(n)
+			rdy = n.RdyAll()
+			p.firstCheck(n)
+		}
+		if !rdy {
+			p.finish(n, false)
+			p.mu.Unlock()
+			continue
+		}
+		p.mu.Unlock()
+
+		err := s.call(n)
+
+		p.mu.Lock()
+		exited := s.commit(n, err, p.notify)
+		p.finish(n, !exited)
+		p.mu.Unlock()
+	}
+}
+
+// notify queues a node to be checked for ready, unless already queued
+// or exited
+func (p *pool) notify(n *fgbase.Node) {
+	if p.s.exited[n] {
+		return
+	}
+	switch p.state[n] {
+	case poolIdle:
+		p.state[n] = poolQueued
+		p.busy++
+		p.queue <- n
+	case poolRunning:
+		p.state[n] = poolRerun
+	}
+}
+
+// firstCheck queues the upstream nodes of a node checked for the first
+// time, since a ready func can give them room then, as the wait of a
+// loop does
+func (p *pool) firstCheck(n *fgbase.Node) {
+	if p.checked[n] {
+		return
+	}
+	p.checked[n] = true
+	for _, e := range n.Srcs {
+		if e == nil || e.IsConst() {
+			continue
+		}
+		for i := 0; i < e.SrcCnt(); i++ {
+			if u := e.SrcNode(i); u != nil {
+				p.notify(u)
+			}
+		}
+	}
+}
+
+// notifyAll queues every node to be checked for ready
+func (p *pool) notifyAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, n := range p.s.nodes {
+		p.notify(n)
+	}
+}
+
+// finish is called when a worker is done with a node, to queue it again
+// if it might be ready
+func (p *pool) finish(n *fgbase.Node, again bool) {
+	if !p.s.exited[n] && (again || p.state[n] == poolRerun) {
+		p.state[n] = poolQueued
+		p.queue <- n
+		return
+	}
+	p.state[n] = poolIdle
+	if p.busy--; p.busy == 0 {
+		select {
+		case p.idle <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runState tracks one run of a flowgraph.  It collects hub errors, counts
+// hubs still running, and drains the flowgraph with EOS once the context
+// is done.
+type runState struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	policy *ErrorPolicy
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	errs   []error
+	done   map[*fgbase.Node]bool
+	exited chan struct{} // closed when every hub has exited
+	hstats map[*fgbase.Node]*hubStats
+	pstats map[pipeKey]*pipeStats
+	looped map[*fgbase.Node]bool // hubs drained in order, see loopedNodes
+
+	fires    int64         // count of firings, for the stall watchdog
+	stalled  chan struct{} // closed when a stall fails the run
+	stop     chan struct{} // closed to stop the stall watchdog
+	watched  chan struct{} // closed when the stall watchdog returns
+	stopOnce sync.Once
+
+	events *eventRecorder // firings written to Options.TraceEvents
+	vcd    *vcdRecorder   // pipe traffic recorded for Options.VCD
+	vcdTo  io.Writer
+
+	opts     Options       // options of the run, with defaults in place of zero fields
+	trace    *log.Logger   // trace output of the run, to Options.TraceWriter
+	cycles   int64         // clock cycles run by the Clocked executor, firings by the Sequential
+	executed chan struct{} // closed when the executor returns
+}
+
+func newRunState(ctx context.Context, policy *ErrorPolicy, opts Options) *runState {
+	rs := &runState{parent: ctx, policy: policy, opts: opts, done: make(map[*fgbase.Node]bool)}
+	rs.trace = log.New(opts.TraceWriter, "", 0)
+	rs.ctx, rs.cancel = context.WithCancel(ctx)
+	return rs
+}
+
+// record collects an error
+func (rs *runState) record(err error) {
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.errs = append(rs.errs, err)
+}
+
+// fail collects an error and stops the flowgraph
+func (rs *runState) fail(err error) {
+	if rs == nil {
+		return
+	}
+	rs.record(err)
+	rs.cancel()
+}
+
+// exit notes a hub has returned EOS and will fire no more
+func (rs *runState) exit(n *fgbase.Node) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if !rs.done[n] {
+		rs.done[n] = true
+		rs.wg.Done()
+	}
+}
+
+// wrap wraps the ready and fire funcs of a node.  A hub that consumes or
+// returns an EOS forwards EOS on every result and exits.  Once the context is done every hub
+// does the same on its next firing, with source hubs emitting EOS in place of
+// new data, except looped hubs, which keep firing until EOS reaches them.
+func (rs *runState) wrap(n *fgbase.Node) {
+	rs.wg.Add(1)
+
+	hs := rs.hstats[n]
+	rdy := n.RdyFunc
+	n.RdyFunc = func(n *fgbase.Node) bool {
+		if rs.draining(n) {
+			return drainRdy(n)
+		}
+		var r bool
+		if rdy == nil {
+			r = n.DefaultRdyFunc()
+		} else {
+			r = rdy(n)
+		}
+		hs.ready(n, r)
+		return r
+	}
+
+	fire := n.FireFunc
+	if fire == nil {
+		fire = passFire
+	}
+	n.FireFunc = func(n *fgbase.Node) error {
+		if rs.draining(n) {
+			rs.exit(n)
+			return drainFire(n)
+		}
+		atomic.AddInt64(&rs.fires, 1)
+		begin := time.Now()
+		err := fire(n)
+		if rs.events != nil {
+			rs.events.fired(n, begin)
+		}
+		rs.countFire(n)
+		rs.traceFire(n)
+		if err == nil && consumedEOS(n) || isEOS(err) {
+			err = drainFire(n)
+		}
+		if rs.vcd != nil {
+			rs.vcd.fired(n)
+		}
+		if err != nil {
+			if errors.Is(err, EOS) {
+				rs.exit(n)
+			} else {
+				rs.record(fmt.Errorf("hub %q: %w", n.Name, err))
+			}
+		}
+		return err
+	}
+}
+
+// tracef writes trace output for a hub, for Options.TraceLevel VV and up
+func (rs *runState) tracef(n *fgbase.Node, format string, v ...interface{}) {
+	if rs.opts.TraceLevel >= fgbase.VV {
+		rs.trace.Printf("%s:  "+format, append([]interface{}{n.Name}, v...)...)
+	}
+}
+
+// traceFire writes the values a hub took and put in a firing
+func (rs *runState) traceFire(n *fgbase.Node) {
+	if rs.opts.TraceLevel < fgbase.VV {
+		return
+	}
+	var in, out []interface{}
+	for _, e := range n.Srcs {
+		if e != nil && e.Flow {
+			in = append(in, e.Val)
+		}
+	}
+	for _, e := range n.Dsts {
+		if e != nil && e.Val != nil {
+			out = append(out, e.Val)
+		}
+	}
+	rs.tracef(n, "%v -> %v\n", in, out)
+}
+
+// draining returns true once the run is cancelled, for a hub that is not
+// looped or that has EOS waiting on a source.  A Wait hub holds its EOS
+// until the loop is empty, so is left to pass it on itself.  A run cut
+// short by an error, a stall, or Options.RunTime drains every hub at once.
+func (rs *runState) draining(n *fgbase.Node) bool {
+	if rs.ctx.Err() == nil {
+		return false
+	}
+	if rs.parent.Err() == nil || !rs.looped[n] {
+		return true
+	}
+	if h, ok := n.Owner.(Hub); ok && h.HubCode() == Wait {
+		return false
+	}
+	for _, e := range n.Srcs {
+		if !e.IsConst() && isEOS(e.Val) {
+			return true
+		}
+	}
+	return false
+}
+
+// loopedNodes returns the hubs of While and During loops and every hub
+// downstream of one.  These drain in order once the context of a run is
+// done, so the values already let into a loop still come out of it.
+func loopedNodes(nodes []*fgbase.Node) map[*fgbase.Node]bool {
+	looped := make(map[*fgbase.Node]bool)
+	var walk func(n *fgbase.Node)
+	walk = func(n *fgbase.Node) {
+		if looped[n] {
+			return
+		}
+		looped[n] = true
+		for _, e := range n.Dsts {
+			if e == nil {
+				continue
+			}
+			for i := 0; i < e.DstCnt(); i++ {
+				if d := e.DstNode(i); d != nil {
+					walk(d)
+				}
+			}
+		}
+	}
+	for _, n := range nodes {
+		if h, ok := n.Owner.(Hub); ok && h.HubCode() == Wait {
+			walk(n)
+		}
+	}
+	return looped
+}
+
+// watchExits closes exited once every wrapped hub has exited
+func (rs *runState) watchExits() {
+	rs.exited = make(chan struct{})
+	go func() {
+		rs.wg.Wait()
+		close(rs.exited)
+	}()
+}
+
+// wait waits for every hub to exit, for a stall to fail the run, or for
+// the executor to return, as the Clocked and Sequential executors do after
+// Options.MaxCycles, then cancels what is left of the run, waits for the
+// executor to return, and returns the result of the run
+func (rs *runState) wait() error {
+	select {
+	case <-rs.exited:
+	case <-rs.stalled:
+	case <-rs.executed:
+	}
+	rs.cancel()
+	<-rs.executed
+	rs.stopWatch()
+	rs.writeEvents()
+	rs.writeVCD()
+	return rs.result()
+}
+
+// runGraph runs the nodes for Run.  A stall that fails the run cancels
+// it, which drains the stalled hubs, so the executor returns then too.
+func (rs *runState) runGraph(nodes []*fgbase.Node) {
+	defer rs.cancel()
+	defer rs.writeVCD()
+	defer rs.writeEvents()
+	defer rs.stopWatch()
+	rs.execute(nodes, false)
+}
+
+// execute runs the nodes with the executor of the run, with
+// fgbase.RunGraph and a canceller for Goroutines.  If drain is set a run
+// with nothing ready waits to be cancelled and drained, as for RunContext,
+// otherwise it ends then or after Options.RunTime.  A Goroutines run is
+// cancelled once it ends, so hubs still running drain and exit, and the
+// canceller is waited for either way.
+func (rs *runState) execute(nodes []*fgbase.Node, drain bool) {
+	if rs.opts.Executor == Goroutines {
+		c := rs.canceller(nodes)
+		cancelled := make(chan struct{})
+		go func() {
+			defer close(cancelled)
+			rs.cancelWhenDone(c)
+		}()
+		ran := make(chan struct{})
+		go func() {
+			defer close(ran)
+			fgbase.RunGraph(nodes)
+		}()
+		if drain {
+			<-cancelled
+			<-ran
+			return
+		}
+		var timeout <-chan time.Time
+		if rs.opts.RunTime > 0 {
+			t := time.NewTimer(rs.opts.RunTime)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-rs.exited:
+			<-ran
+		case <-timeout:
+		}
+		rs.cancel()
+		<-cancelled
+		return
+	}
+	s := newScheduler(rs, nodes)
+	s.drain = drain
+	if !drain && rs.opts.RunTime > 0 {
+		s.until = time.Now().Add(rs.opts.RunTime)
+	}
+	switch rs.opts.Executor {
+	case Clocked:
+		s.runClocked(rs.opts.MaxCycles)
+	case Sequential:
+		s.runSequential(rs.opts.Schedule, rs.opts.Seed, rs.opts.HubPriority, rs.opts.MaxCycles)
+	case WorkerPool:
+		s.runPool(rs.opts.Workers)
+	}
+}
+
+// canceller returns a node that wakes the hubs of a run once it is
+// cancelled, even a hub blocked waiting on a source that will never get a
+// value.  Its results are every source pipe of the run, though it is not
+// one of the writers the hubs answer, and firing it puts EOS on each one
+// read by a hub yet to exit, so every hub drains and exits.  Once the
+// context of the run is done pipes read by a looped hub are left alone, as
+// their writers drain into them in order.
+func (rs *runState) canceller(nodes []*fgbase.Node) *fgbase.Node {
+	var dsts []*fgbase.Edge
+	for _, n := range nodes {
+	srcs:
+		for _, e := range n.Srcs {
+			if e == nil || e.IsConst() {
+				continue
+			}
+			for _, d := range dsts {
+				if d.Same(e) {
+					continue srcs
+				}
+			}
+			c := *e
+			c.Val = nil
+			dsts = append(dsts, &c)
+		}
+	}
+
+	c := fgbase.MakeNode("cancel", nil, make([]*fgbase.Edge, len(dsts)), nil, func(c *fgbase.Node) error {
+		var eos []*fgbase.Edge
+		ordered := rs.parent.Err() != nil
+		rs.mu.Lock()
+		for _, e := range c.Dsts {
+			live, looped := false, false
+			for i := 0; i < e.DstCnt(); i++ {
+				if d := e.DstNode(i); d != nil && !rs.done[d] {
+					live, looped = true, looped || ordered && rs.looped[d]
+				}
+			}
+			if live && !looped {
+				eos = append(eos, e)
+			}
+		}
+		rs.mu.Unlock()
+		for _, e := range eos { // hubs exit by taking these, so not under rs.mu
+			e.DstPut(EOS)
+		}
+		return EOS
+	})
+	copy(c.Dsts, dsts)
+	return &c
+}
+
+// cancelWhenDone fires a canceller once the run is cancelled, unless every
+// hub exits first
+func (rs *runState) cancelWhenDone(c *fgbase.Node) {
+	select {
+	case <-rs.ctx.Done():
+		c.Fire()
+	case <-rs.exited:
+	}
+}
+
+// writeEvents ends the firings written to Options.TraceEvents
+func (rs *runState) writeEvents() {
+	if rs.events == nil {
+		return
+	}
+	if err := rs.events.close(); err != nil {
+		rs.record(fmt.Errorf("trace events: %w", err))
+	}
+}
+
+// writeVCD writes the pipe traffic recorded for Options.VCD
+func (rs *runState) writeVCD() {
+	if rs.vcd == nil {
+		return
+	}
+	if err := rs.vcd.write(rs.vcdTo); err != nil {
+		rs.record(fmt.Errorf("vcd: %w", err))
+	}
+}
+
+// result returns the hub errors joined together, otherwise the context
+// error if the run was cut short.
+func (rs *runState) result() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if len(rs.errs) > 0 {
+		return errors.Join(rs.errs...)
+	}
+	return rs.parent.Err()
+}
+
+// passFire is the fire func of a Pass hub
+func passFire(n *fgbase.Node) error {
+	for i := range n.Srcs {
+		n.Dsts[i].DstPut(n.Srcs[i].SrcGet())
+	}
+	return nil
+}
+
+// consumedEOS returns true if a fire consumed an EOS
+func consumedEOS(n *fgbase.Node) bool {
+	for _, e := range n.Srcs {
+		if e.Flow && isEOS(e.Val) {
+			return true
+		}
+	}
+	return false
+}
+
+// drainRdy is ready for source hubs, and for others once any source has a value
+func drainRdy(n *fgbase.Node) bool {
+	if n.SrcCnt() == 0 {
+		return true
+	}
+	for _, e := range n.Srcs {
+		if !e.IsConst() && e.Val != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// drainFire consumes what is waiting on the sources and puts EOS on every
+// result not already given a value.
+func drainFire(n *fgbase.Node) error {
+	for _, e := range n.Srcs {
+		e.Flow = !e.IsConst() && e.Val != nil
+	}
+	for _, e := range n.Dsts {
+		if e.Val == nil {
+			e.DstPut(EOS)
+		}
+	}
+	return EOS
+}
+
+// isEOS returns true if v is EOS
+func isEOS(v interface{}) bool {
+	err, ok := v.(error)
+	return ok && errors.Is(err, EOS)
+}
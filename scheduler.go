@@ -0,0 +1,260 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"errors"
+	"time"
+)
+
+// Executor is how a run fires the hubs of a flowgraph
+type Executor int
+
+const (
+	Goroutines Executor = iota // a goroutine per hub, each firing as soon as ready
+	Clocked                    // every ready hub fires once a clock cycle
+	Sequential                 // one hub fires at a time, picked by Options.Schedule
+	WorkerPool                 // a pool of Options.Workers goroutines fires hubs as they become ready
+)
+
+// Executor strings for pretty printing
+var executorNames = map[Executor]string{
+	Goroutines: "Goroutines",
+	Clocked:    "Clocked",
+	Sequential: "Sequential",
+	WorkerPool: "WorkerPool",
+}
+
+// String returns the name of an Executor
+func (e Executor) String() string {
+	return executorNames[e]
+}
+
+// scheduler fires the flattened nodes of a run without a goroutine each.
+// It moves values between nodes itself, queueing them by the source edge
+// of each downstream node, and gives back room on a result edge once
+// every downstream node has taken the value, as fgbase does with acks.
+type scheduler struct {
+	rs     *runState
+	nodes  []*fgbase.Node
+	inbox  map[*fgbase.Edge]*tokenQueue   // by source edge of a node
+	fanout map[*fgbase.Edge][]*tokenQueue // by result edge of a node
+	exited map[*fgbase.Node]bool
+	until  time.Time // stop at this time if not zero
+	drain  bool      // when nothing is ready wait for the run to be cancelled, then drain
+	woken  bool      // EOS has been put on the empty queues of a cancelled run
+}
+
+// token is a value waiting on a pipe, and the put it came from
+type token struct {
+	v    interface{}
+	sent *sent
+}
+
+// sent is a value put on a result edge of a node, waiting for every
+// downstream node to take it
+type sent struct {
+	n    *fgbase.Node
+	e    *fgbase.Edge
+	left int
+}
+
+// tokenQueue is the values waiting for one downstream node on a pipe
+type tokenQueue struct {
+	n    *fgbase.Node
+	toks []token
+}
+
+func (q *tokenQueue) head() interface{} {
+	if len(q.toks) == 0 {
+		return nil
+	}
+	return q.toks[0].v
+}
+
+func (q *tokenQueue) push(t token) {
+	q.toks = append(q.toks, t)
+}
+
+// pop takes the head value, giving back room on the result edge it came
+// from once every downstream node has taken it.  Returns the node given
+// room, if any.
+func (q *tokenQueue) pop() *fgbase.Node {
+	if len(q.toks) == 0 {
+		return nil
+	}
+	t := q.toks[0]
+	q.toks = q.toks[1:]
+	if t.sent != nil {
+		if t.sent.left--; t.sent.left == 0 {
+			t.sent.e.RdyCnt++
+			return t.sent.n
+		}
+	}
+	return nil
+}
+
+// newScheduler readies the queues between the nodes of a run, with the
+// initial values of their pipes
+func newScheduler(rs *runState, nodes []*fgbase.Node) *scheduler {
+	s := &scheduler{
+		rs:     rs,
+		nodes:  nodes,
+		inbox:  make(map[*fgbase.Edge]*tokenQueue),
+		fanout: make(map[*fgbase.Edge][]*tokenQueue),
+		exited: make(map[*fgbase.Node]bool),
+	}
+
+	inits := make(map[*fgbase.Edge][]*tokenQueue) // by upstream edge
+	for _, n := range nodes {
+		for _, e := range n.Srcs {
+			if e == nil || e.IsConst() {
+				continue
+			}
+			q := &tokenQueue{n: n}
+			s.inbox[e] = q
+			if e.Val != nil {
+				q.push(token{v: e.Val})
+			}
+		}
+	}
+	for _, n := range nodes {
+		for _, e := range n.Dsts {
+			if e == nil || e.IsSink() {
+				continue
+			}
+			seen := make(map[*fgbase.Node]bool)
+			for i := 0; i < e.DstCnt(); i++ {
+				d := e.DstNode(i)
+				if d == nil || seen[d] {
+					continue
+				}
+				seen[d] = true
+				for _, de := range d.Srcs {
+					if q := s.inbox[de]; q != nil && de.Same(e) {
+						s.fanout[e] = append(s.fanout[e], q)
+						if len(q.toks) > 0 && q.toks[0].sent == nil {
+							inits[e] = append(inits[e], q)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// the first upstream node holding an initial value waits for it to be taken
+	for _, n := range nodes {
+		for _, e := range n.Dsts {
+			if e == nil || e.Val == nil {
+				continue
+			}
+			if qs := inits[e]; len(qs) > 0 && qs[0].toks[0].sent == nil {
+				st := &sent{n: n, e: e, left: len(qs)}
+				for _, q := range qs {
+					q.toks[0].sent = st
+				}
+				e.RdyCnt--
+			}
+			e.Val = nil
+		}
+	}
+	return s
+}
+
+// load sets the source edges of a node to the values at the head of its
+// queues, ready for its ready func
+func (s *scheduler) load(n *fgbase.Node) {
+	for _, e := range n.Srcs {
+		if q := s.inbox[e]; q != nil {
+			e.Val = q.head()
+			e.Flow = false
+		}
+	}
+}
+
+// fire fires a loaded node and commits what it did.  Returns true if the
+// node has exited.
+func (s *scheduler) fire(n *fgbase.Node) bool {
+	return s.commit(n, s.call(n), nil)
+}
+
+// call calls the fire func of a loaded node
+func (s *scheduler) call(n *fgbase.Node) error {
+	n.Cnt++
+	return n.FireFunc(n)
+}
+
+// commit takes the values a node consumed off its queues and puts the
+// values it produced on the queues downstream, calling notify if not nil
+// with each node that may now be ready.  Returns true if the node has
+// exited.
+func (s *scheduler) commit(n *fgbase.Node, err error, notify func(*fgbase.Node)) bool {
+	for _, e := range n.Srcs {
+		if q := s.inbox[e]; q != nil && e.Flow && e.Val != nil {
+			if u := q.pop(); u != nil && notify != nil {
+				notify(u)
+			}
+		}
+		if e != nil && !e.IsConst() {
+			e.Flow = false
+		}
+	}
+	for _, e := range n.Dsts {
+		if e == nil || e.Val == nil {
+			continue
+		}
+		if qs := s.fanout[e]; len(qs) > 0 {
+			st := &sent{n: n, e: e, left: len(qs)}
+			for _, q := range qs {
+				q.push(token{e.Val, st})
+				if notify != nil {
+					notify(q.n)
+				}
+			}
+			e.RdyCnt--
+		}
+		e.Val = nil
+	}
+	if errors.Is(err, EOS) {
+		s.exited[n] = true
+	}
+	return s.exited[n]
+}
+
+// done returns true if every node has exited
+func (s *scheduler) done() bool {
+	return len(s.exited) == len(s.nodes)
+}
+
+// idle is called when no node is ready.  Returns true if the run should
+// go on, once cancelled to be drained.
+func (s *scheduler) idle() bool {
+	if !s.drain || s.woken {
+		return false
+	}
+	<-s.rs.ctx.Done()
+	s.wake()
+	return true
+}
+
+// wake puts EOS on every empty source queue of a node yet to exit, so a
+// node waiting on a source that will never get a value drains and exits
+// as well
+func (s *scheduler) wake() {
+	s.woken = true
+	for _, n := range s.nodes {
+		if s.exited[n] {
+			continue
+		}
+		for _, e := range n.Srcs {
+			if q := s.inbox[e]; q != nil && len(q.toks) == 0 {
+				q.push(token{v: EOS})
+			}
+		}
+	}
+}
+
+// expired returns true if the run has gone on past its run time
+func (s *scheduler) expired() bool {
+	return !s.until.IsZero() && time.Now().After(s.until)
+}
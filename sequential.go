@@ -0,0 +1,89 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"math/rand"
+	"sort"
+	"sync/atomic"
+)
+
+// SchedulePolicy is how the Sequential executor picks the next hub to fire
+type SchedulePolicy int
+
+const (
+	RoundRobin SchedulePolicy = iota // the next ready hub in order of flattening after the last to fire
+	Random                           // a ready hub picked at random, the same for the same Options.Seed
+	Priority                         // the ready hub of highest Options.HubPriority, first in order of flattening if tied
+)
+
+// SchedulePolicy strings for pretty printing
+var schedulePolicyNames = map[SchedulePolicy]string{
+	RoundRobin: "RoundRobin",
+	Random:     "Random",
+	Priority:   "Priority",
+}
+
+// String returns the name of a SchedulePolicy
+func (p SchedulePolicy) String() string {
+	return schedulePolicyNames[p]
+}
+
+// runSequential runs the nodes one firing at a time on the goroutine of
+// the run, checking them for ready in the order given by the policy and
+// firing the first that is.  The same flowgraph with the same policy and
+// seed fires in the same order every run.  The run ends when every node
+// has exited, no node is ready, or after maxFires firings.
+func (s *scheduler) runSequential(policy SchedulePolicy, seed int64, priority func(Hub) int, maxFires int64) {
+	order := append([]*fgbase.Node(nil), s.nodes...)
+	var rnd *rand.Rand
+	switch policy {
+	case Random:
+		rnd = rand.New(rand.NewSource(seed))
+	case Priority:
+		if priority != nil {
+			sort.SliceStable(order, func(i, j int) bool {
+				return priority(order[i].Owner.(Hub)) > priority(order[j].Owner.(Hub))
+			})
+		}
+	}
+
+	next := 0
+	for !s.done() && !s.expired() {
+		if maxFires > 0 && atomic.LoadInt64(&s.rs.cycles) >= maxFires {
+			return
+		}
+		if rnd != nil {
+			rnd.Shuffle(len(order), func(i, j int) {
+				order[i], order[j] = order[j], order[i]
+			})
+		}
+
+		fired := false
+		for i := range order {
+			j := i
+			if policy == RoundRobin {
+				j = (next + i) % len(order)
+			}
+			n := order[j]
+			if s.exited[n] {
+				continue
+			}
+			s.load(n)
+			if !n.RdyAll() {
+				continue
+			}
+			c := atomic.AddInt64(&s.rs.cycles, 1)
+			s.rs.tracef(n, "fired in step %d\n", c)
+			s.fire(n)
+			next, fired = j+1, true
+			break
+		}
+		if !fired {
+			if s.idle() {
+				continue
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stall is a snapshot of a flowgraph in which no hub has fired for
+// Options.StallTimeout, taken by the stall watchdog
+type Stall struct {
+	Title   string
+	Timeout time.Duration
+	Hubs    []StalledHub // hubs waiting on a pipe, in order of flattening
+	Cycle   []string     // hubs waiting on each other in a cycle, if any
+	dot     string
+}
+
+// StalledHub is a hub waiting on empty source pipes or full result pipes
+type StalledHub struct {
+	Name  string
+	Empty []string // source pipes with no value
+	Full  []string // result pipes with no room
+}
+
+// StallError is returned by Run and RunContext when Options.FailOnStall is
+// set and the flowgraph stalls
+type StallError struct {
+	Stall *Stall
+}
+
+// Error describes the stall
+func (e *StallError) Error() string {
+	return e.Stall.String()
+}
+
+// String describes each stalled hub and the cycle of waiting hubs
+func (s *Stall) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "flowgraph %q stalled, no hub fired for %v\n", s.Title, s.Timeout)
+	for _, h := range s.Hubs {
+		for _, p := range h.Empty {
+			fmt.Fprintf(&b, "hub %q waits on empty source pipe %s\n", h.Name, p)
+		}
+		for _, p := range h.Full {
+			fmt.Fprintf(&b, "hub %q waits on full result pipe %s\n", h.Name, p)
+		}
+	}
+	if len(s.Cycle) > 0 {
+		b.WriteString("waiting in a cycle:")
+		for _, h := range append(s.Cycle, s.Cycle[0]) {
+			fmt.Fprintf(&b, " %q", h)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Dot returns the flowgraph as a dot graph, with stalled hubs in red,
+// empty pipes dashed, full pipes bold, and the cycle of waiting hubs
+// drawn in red
+func (s *Stall) Dot() string {
+	return s.dot
+}
+
+// watch starts the stall watchdog for a run, which checks every d that some
+// hub has fired.  A stall is written to the trace output, or fails the run
+// if fail is set.
+func (rs *runState) watch(title string, nodes []*fgbase.Node, names map[*fgbase.Node]string, d time.Duration, fail bool) {
+	rs.stalled = make(chan struct{})
+	rs.stop = make(chan struct{})
+	rs.watched = make(chan struct{})
+	go func() {
+		defer close(rs.watched)
+		t := time.NewTicker(d)
+		defer t.Stop()
+		last, dumped := int64(0), false
+		for {
+			select {
+			case <-rs.stop:
+				return
+			case <-rs.ctx.Done():
+				return
+			case <-t.C:
+			}
+			if n := atomic.LoadInt64(&rs.fires); n != last {
+				last, dumped = n, false
+				continue
+			}
+			if dumped {
+				continue
+			}
+			s := rs.snapshot(title, nodes, names, d)
+			if len(s.Hubs) == 0 {
+				continue
+			}
+			if !fail {
+				rs.trace.Printf("%s", s)
+				dumped = true
+				continue
+			}
+			rs.fail(&StallError{s})
+			close(rs.stalled)
+			return
+		}
+	}()
+}
+
+// stopWatch stops the stall watchdog, if there is one, and waits for it
+func (rs *runState) stopWatch() {
+	if rs.stop == nil {
+		return
+	}
+	rs.stopOnce.Do(func() { close(rs.stop) })
+	<-rs.watched
+}
+
+// snapshot finds the hubs still running that wait on an empty source or
+// a full result, and the hubs each waits on.  The ports waited on are those
+// each hub noted the last time it was not ready, so the pipes of running
+// hubs are not looked at.
+func (rs *runState) snapshot(title string, nodes []*fgbase.Node, names map[*fgbase.Node]string, d time.Duration) *Stall {
+	rs.mu.Lock()
+	done := make(map[*fgbase.Node]bool)
+	for n := range rs.done {
+		done[n] = true
+	}
+	rs.mu.Unlock()
+
+	s := &Stall{Title: title, Timeout: d}
+	waits := make(map[*fgbase.Node][]*fgbase.Node)
+	empty := make(map[*fgbase.Edge]bool)
+	full := make(map[*fgbase.Edge]bool)
+	for _, n := range nodes {
+		if done[n] {
+			continue
+		}
+		h := StalledHub{Name: names[n]}
+		srcs, dsts := rs.hstats[n].waitingOn()
+		for _, j := range srcs {
+			e := n.Srcs[j]
+			h.Empty = append(h.Empty, edgeDesc(e, names))
+			empty[e] = true
+			for i := 0; i < e.SrcCnt(); i++ {
+				waits[n] = append(waits[n], e.SrcNode(i))
+			}
+		}
+		for _, j := range dsts {
+			e := n.Dsts[j]
+			h.Full = append(h.Full, edgeDesc(e, names))
+			full[e] = true
+			for i := 0; i < e.DstCnt(); i++ {
+				waits[n] = append(waits[n], e.DstNode(i))
+			}
+		}
+		if len(h.Empty) > 0 || len(h.Full) > 0 {
+			s.Hubs = append(s.Hubs, h)
+		} else {
+			delete(waits, n)
+		}
+	}
+
+	cycle := waitCycle(nodes, waits)
+	for _, n := range cycle {
+		s.Cycle = append(s.Cycle, names[n])
+	}
+	s.dot = stallDot(s, nodes, names, waits, cycle, empty, full)
+	return s
+}
+
+// waitCycle returns a cycle of nodes each waiting on the next, or nil
+func waitCycle(nodes []*fgbase.Node, waits map[*fgbase.Node][]*fgbase.Node) []*fgbase.Node {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*fgbase.Node]int)
+	var stack []*fgbase.Node
+	var cycle []*fgbase.Node
+	var visit func(n *fgbase.Node) bool
+	visit = func(n *fgbase.Node) bool {
+		state[n] = visiting
+		stack = append(stack, n)
+		for _, m := range waits[n] {
+			if _, ok := waits[m]; !ok {
+				continue
+			}
+			switch state[m] {
+			case visiting:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == m {
+						cycle = append(cycle, stack[i:]...)
+						return true
+					}
+				}
+			case unvisited:
+				if visit(m) {
+					return true
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[n] = visited
+		return false
+	}
+	for _, n := range nodes {
+		if _, ok := waits[n]; ok && state[n] == unvisited && visit(n) {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// stallDot draws the flattened flowgraph with the stall highlighted
+func stallDot(s *Stall, nodes []*fgbase.Node, names map[*fgbase.Node]string,
+	waits map[*fgbase.Node][]*fgbase.Node, cycle []*fgbase.Node,
+	empty, full map[*fgbase.Edge]bool) string {
+
+	next := make(map[*fgbase.Node]*fgbase.Node)
+	for i, n := range cycle {
+		next[n] = cycle[(i+1)%len(cycle)]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", s.Title)
+	for _, n := range nodes {
+		attr := ""
+		if _, ok := waits[n]; ok {
+			attr = " [color=red]"
+		}
+		fmt.Fprintf(&b, "\t%q%s;\n", names[n], attr)
+	}
+	for _, n := range nodes {
+		for _, e := range n.Dsts {
+			if e == nil {
+				continue
+			}
+			for i := 0; i < e.DstCnt(); i++ {
+				d := e.DstNode(i)
+				if d == nil {
+					continue
+				}
+				attrs := []string{fmt.Sprintf("label=%q", e.Name)}
+				if empty[dstCopy(d, e)] {
+					attrs = append(attrs, "style=dashed")
+				}
+				if full[e] {
+					attrs = append(attrs, "style=bold")
+				}
+				if next[n] == d || next[d] == n {
+					attrs = append(attrs, "color=red", "penwidth=2")
+				}
+				fmt.Fprintf(&b, "\t%q -> %q [%s];\n", names[n], names[d], strings.Join(attrs, ", "))
+			}
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dstCopy returns the copy of an edge held as a source by node n
+func dstCopy(n *fgbase.Node, e *fgbase.Edge) *fgbase.Edge {
+	for _, s := range n.Srcs {
+		if s != nil && s.Same(e) {
+			return s
+		}
+	}
+	return nil
+}
+
+// edgeDesc names an edge for a stall, by the hub it comes from if unnamed
+func edgeDesc(e *fgbase.Edge, names map[*fgbase.Node]string) string {
+	switch {
+	case e.Name != "":
+		return fmt.Sprintf("%q", e.Name)
+	case e.SrcCnt() > 0 && e.SrcNode(0) != nil:
+		return fmt.Sprintf("from hub %q", names[e.SrcNode(0)])
+	}
+	return "(unnamed)"
+}
+
+// hubNames maps the node of every hub to its dotted name, and the nodes
+// of a replicated hub to the name of the hub
+func (fg *flowgraph) hubNames() map[*fgbase.Node]string {
+	names := make(map[*fgbase.Node]string)
+	fg.WalkHubs(func(path string, h Hub) bool {
+		n := h.Base().(*fgbase.Node)
+		names[n] = path
+		if hfg, ok := h.Flowgraph().(*flowgraph); ok {
+			for _, r := range hfg.replicas[n] {
+				names[r] = path
+			}
+		}
+		return true
+	})
+	return names
+}
@@ -0,0 +1,381 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatsBuckets are the upper bounds of the histogram of time spent in the
+// Transform, Retrieve, or Transmit of a hub.  The last bucket of
+// HubStats.UserHist counts the longer times.
+var StatsBuckets = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// Stats is a snapshot of the runtime metrics of a flowgraph and every
+// GraphHub inside it, named by dotted path
+type Stats struct {
+	Hubs   []HubStats
+	Pipes  []PipeStats
+	Cycles int64 // clock cycles run by the Clocked executor, firings by the Sequential
+}
+
+// HubStats are the runtime metrics of one hub
+type HubStats struct {
+	Name       string
+	Code       HubCode
+	Fires      int64         // times fired
+	UserTime   time.Duration // total time in Transform, Retrieve, or Transmit
+	UserMin    time.Duration
+	UserMax    time.Duration
+	UserHist   []int64       // calls by StatsBuckets, plus one for longer
+	SourceWait time.Duration // time waiting for source values
+	ResultWait time.Duration // time waiting for room on result pipes
+}
+
+// PipeStats are the runtime metrics of one pipe
+type PipeStats struct {
+	Name      string
+	Tokens    int64   // values put on the pipe
+	Occupancy float64 // average number of values waiting on the pipe
+}
+
+// Stats returns the runtime metrics of the last or current run, zero for a
+// flowgraph not yet run.  Safe to call while running.
+func (fg *flowgraph) Stats() Stats {
+	var s Stats
+	var hs map[*fgbase.Node]*hubStats
+	var ps map[pipeKey]*pipeStats
+	if rs := fg.rs.Load(); rs != nil {
+		hs, ps = rs.hstats, rs.pstats
+		s.Cycles = atomic.LoadInt64(&rs.cycles)
+	}
+
+	fg.WalkHubs(func(path string, h Hub) bool {
+		if _, ok := h.(GraphHub); ok {
+			return true
+		}
+		st := HubStats{Name: path, Code: h.HubCode(), UserHist: make([]int64, len(StatsBuckets)+1)}
+		n := h.Base().(*fgbase.Node)
+		if reps := h.Flowgraph().(*flowgraph).replicas[n]; reps != nil {
+			replicaStats(&st, hs, reps)
+		} else if x := hs[n]; x != nil {
+			x.snapshot(&st)
+		}
+		s.Hubs = append(s.Hubs, st)
+		return true
+	})
+
+	fg.walkPipeKeys(func(path string, k pipeKey) {
+		st := PipeStats{Name: path}
+		if x := ps[runKey(k)]; x != nil {
+			x.snapshot(&st)
+		}
+		s.Pipes = append(s.Pipes, st)
+	})
+	return s
+}
+
+// replicaStats sums the metrics of the copies of a replicated hub into st,
+// with the wait for sources of the distributor and the wait for room of
+// the reorder node, the first and last of reps
+func replicaStats(st *HubStats, hs map[*fgbase.Node]*hubStats, reps []*fgbase.Node) {
+	var calls int64
+	for _, n := range reps[1 : len(reps)-1] {
+		x := hs[n]
+		if x == nil {
+			continue
+		}
+		c := HubStats{UserHist: make([]int64, len(StatsBuckets)+1)}
+		x.snapshot(&c)
+		var k int64
+		for i, v := range c.UserHist {
+			st.UserHist[i] += v
+			k += v
+		}
+		if k > 0 && (calls == 0 || c.UserMin < st.UserMin) {
+			st.UserMin = c.UserMin
+		}
+		if c.UserMax > st.UserMax {
+			st.UserMax = c.UserMax
+		}
+		calls += k
+		st.Fires += c.Fires
+		st.UserTime += c.UserTime
+	}
+	if x := hs[reps[0]]; x != nil {
+		c := HubStats{UserHist: make([]int64, len(StatsBuckets)+1)}
+		x.snapshot(&c)
+		st.SourceWait = c.SourceWait
+	}
+	if x := hs[reps[len(reps)-1]]; x != nil {
+		c := HubStats{UserHist: make([]int64, len(StatsBuckets)+1)}
+		x.snapshot(&c)
+		st.ResultWait = c.ResultWait
+	}
+}
+
+// walkPipeKeys calls f once for every pipe with an upstream hub, with its
+// dotted name.  An unnamed pipe is named by its upstream hub and result
+// port, like "whileCross[2]".
+func (fg *flowgraph) walkPipeKeys(f func(path string, k pipeKey)) {
+	seen := make(map[pipeKey]bool)
+	fg.WalkPipes(func(path string, p Pipe) bool {
+		k, ok := keyOf(p.Base().(*fgbase.Edge))
+		if !ok || seen[k] {
+			return true
+		}
+		seen[k] = true
+		if p.Name() == "" {
+			path += fmt.Sprintf("%s[%d]", k.n.Name, k.i)
+		}
+		f(path, k)
+		return true
+	})
+}
+
+// Stats returns the runtime metrics of the internal flowgraph
+func (gh *graphhub) Stats() Stats {
+	return gh.fg.Stats()
+}
+
+// hubStats collects the metrics of a hub while running.  Only the hub
+// updates them, one firing at a time, so the counts are atomics for Stats
+// to load, and idle and waiting are left to the hub alone.
+type hubStats struct {
+	fires      int64
+	calls      int64 // calls of user code
+	user       int64 // nanoseconds, as are the rest of the times
+	userMin    int64
+	userMax    int64
+	hist       []int64
+	sourceWait int64
+	resultWait int64
+	idle       time.Time // when the hub last finished firing
+	waiting    int       // what the hub is waiting for, 0 if not waiting
+
+	ports bool       // note the ports waited on, for the stall watchdog
+	mu    sync.Mutex // guards empty and full
+	empty []int      // source ports last waited on
+	full  []int      // result ports last waited on
+}
+
+const (
+	waitSource = 1 + iota
+	waitResult
+)
+
+// ready notes whether the hub is ready to fire, and if it was waiting,
+// for how long.  A wait is counted as one for room on a result pipe if
+// the first check that finds the hub not ready finds a full one.  Called
+// by the hub itself, so its pipes are looked at here and not by another
+// goroutine.
+func (hs *hubStats) ready(n *fgbase.Node, rdy bool) {
+	if hs.ports {
+		hs.notePorts(n, rdy)
+	}
+	if !rdy {
+		if hs.waiting == 0 {
+			hs.waiting = waitSource
+			for _, e := range n.Dsts {
+				if e != nil && !e.DstRdy(n) {
+					hs.waiting = waitResult
+					break
+				}
+			}
+		}
+		return
+	}
+	if hs.waiting == 0 {
+		return
+	}
+	d := int64(time.Since(hs.idle))
+	if hs.waiting == waitResult {
+		atomic.AddInt64(&hs.resultWait, d)
+	} else {
+		atomic.AddInt64(&hs.sourceWait, d)
+	}
+	hs.waiting = 0
+}
+
+// notePorts notes the source and result ports the hub waits on, none if
+// ready, for the stall watchdog
+func (hs *hubStats) notePorts(n *fgbase.Node, rdy bool) {
+	var empty, full []int
+	if !rdy {
+		for i, e := range n.Srcs {
+			if e != nil && !e.SrcRdy(n) {
+				empty = append(empty, i)
+			}
+		}
+		for i, e := range n.Dsts {
+			if e != nil && !e.DstRdy(n) {
+				full = append(full, i)
+			}
+		}
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.empty, hs.full = empty, full
+}
+
+// fired counts a firing, ending now
+func (hs *hubStats) fired() {
+	atomic.AddInt64(&hs.fires, 1)
+	hs.idle = time.Now()
+}
+
+// addUser adds the time of one call of user code
+func (hs *hubStats) addUser(d time.Duration) {
+	ns := int64(d)
+	if atomic.AddInt64(&hs.calls, 1) == 1 || ns < atomic.LoadInt64(&hs.userMin) {
+		atomic.StoreInt64(&hs.userMin, ns)
+	}
+	if ns > atomic.LoadInt64(&hs.userMax) {
+		atomic.StoreInt64(&hs.userMax, ns)
+	}
+	i := 0
+	for i < len(StatsBuckets) && d > StatsBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&hs.hist[i], 1)
+	atomic.AddInt64(&hs.user, ns)
+}
+
+// waitingOn returns the source and result ports the hub waited on when
+// last not ready, none if ready since
+func (hs *hubStats) waitingOn() (empty, full []int) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return hs.empty, hs.full
+}
+
+func (hs *hubStats) snapshot(st *HubStats) {
+	st.Fires = atomic.LoadInt64(&hs.fires)
+	st.UserTime = time.Duration(atomic.LoadInt64(&hs.user))
+	st.UserMin = time.Duration(atomic.LoadInt64(&hs.userMin))
+	st.UserMax = time.Duration(atomic.LoadInt64(&hs.userMax))
+	for i := range hs.hist {
+		st.UserHist[i] = atomic.LoadInt64(&hs.hist[i])
+	}
+	st.SourceWait = time.Duration(atomic.LoadInt64(&hs.sourceWait))
+	st.ResultWait = time.Duration(atomic.LoadInt64(&hs.resultWait))
+}
+
+// pipeKey identifies a pipe by its first upstream node and the result port
+// it is on, the same for every copy of the pipe
+type pipeKey struct {
+	n *fgbase.Node
+	i int
+}
+
+// keyOf returns the key of the pipe of an edge, false for one with no
+// upstream node
+func keyOf(e *fgbase.Edge) (pipeKey, bool) {
+	if e == nil || e.SrcCnt() == 0 || e.SrcNode(0) == nil {
+		return pipeKey{}, false
+	}
+	u := e.SrcNode(0)
+	for i, d := range u.Dsts {
+		if d != nil && d.Same(e) {
+			return pipeKey{u, i}, true
+		}
+	}
+	return pipeKey{}, false
+}
+
+// pipeStats collects the metrics of a pipe while running
+type pipeStats struct {
+	mu     sync.Mutex
+	ndst   int       // number of downstream nodes that each take every value
+	tokens int64     // values put
+	held   int       // copies of values waiting, one per downstream node
+	area   float64   // held integrated over time, in copy-seconds
+	start  time.Time // start of the run
+	last   time.Time // last change of held
+}
+
+// add changes the copies of values waiting by k
+func (ps *pipeStats) add(k int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	now := time.Now()
+	ps.area += float64(ps.held) * now.Sub(ps.last).Seconds()
+	ps.last = now
+	ps.held += k
+	if k > 0 {
+		ps.tokens++
+	}
+}
+
+func (ps *pipeStats) snapshot(st *PipeStats) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	now := time.Now()
+	st.Tokens = ps.tokens
+	if t := now.Sub(ps.start).Seconds(); t > 0 {
+		area := ps.area + float64(ps.held)*now.Sub(ps.last).Seconds()
+		st.Occupancy = area / t / float64(ps.ndst)
+	}
+}
+
+// initStats readies the metrics of the nodes of a run
+func (rs *runState) initStats(nodes []*fgbase.Node) {
+	now := time.Now()
+	rs.hstats = make(map[*fgbase.Node]*hubStats)
+	rs.pstats = make(map[pipeKey]*pipeStats)
+	for _, n := range nodes {
+		rs.hstats[n] = &hubStats{
+			hist:  make([]int64, len(StatsBuckets)+1),
+			idle:  now,
+			ports: rs.opts.StallTimeout > 0,
+		}
+		for i, e := range n.Dsts {
+			if e == nil || e.IsSink() {
+				continue
+			}
+			ndst := e.DstCnt()
+			if ndst == 0 {
+				ndst = 1
+			}
+			ps := &pipeStats{ndst: ndst, start: now, last: now}
+			if e.Val != nil {
+				ps.held = ndst // initial value
+			}
+			rs.pstats[pipeKey{n, i}] = ps
+		}
+	}
+}
+
+// countFire counts a firing of a node, and the values it put on its
+// result pipes and took from its source pipes
+func (rs *runState) countFire(n *fgbase.Node) {
+	for i, e := range n.Dsts {
+		if e != nil && e.Val != nil {
+			if ps := rs.pstats[pipeKey{n, i}]; ps != nil {
+				ps.add(ps.ndst)
+			}
+		}
+	}
+	for _, e := range n.Srcs {
+		if e == nil || !e.Flow || e.IsConst() {
+			continue
+		}
+		if k, ok := keyOf(e); ok {
+			if ps := rs.pstats[k]; ps != nil {
+				ps.add(-1)
+			}
+		}
+	}
+	rs.hstats[n].fired()
+}
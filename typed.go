@@ -0,0 +1,236 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"fmt"
+	"reflect"
+)
+
+// TypedPipe is a Pipe for values of type T.  Connecting it to a port of a
+// typed hub (one made with Func1, Func2, or RetrieverFunc) that takes some
+// other type panics, as does connecting two typed ports that disagree.
+// The untyped Pipe is embedded, so a TypedPipe goes anywhere a Pipe does.
+type TypedPipe[T any] struct {
+	Pipe
+}
+
+// NewTypedPipe returns a new unconnected pipe for values of type T
+func NewTypedPipe[T any](fg Flowgraph, name string) TypedPipe[T] {
+	p := fg.NewPipe(name)
+	f := p.(*pipe).fg
+	f.ptypes = append(f.ptypes, pipeType{p, typeOf[T]()})
+	return TypedPipe[T]{p}
+}
+
+// Init sets an initial value for flow, which must be a T
+func (p TypedPipe[T]) Init(v interface{}) Pipe {
+	checkValue[T](p, v)
+	p.Pipe.Init(v)
+	return p
+}
+
+// Const sets a value for continual flow, which must be a T
+func (p TypedPipe[T]) Const(v interface{}) Pipe {
+	checkValue[T](p, v)
+	p.Pipe.Const(v)
+	return p
+}
+
+// Func1 is a func of one source value of type A to one result value of
+// type R.  Provide as init arg to NewHub with AllOf HubCode.
+type Func1[A, R any] func(h Hub, a A) (R, error)
+
+// Transform calls f with its source asserted to type A
+func (f Func1[A, R]) Transform(h Hub, source []interface{}) (result []interface{}, err error) {
+	if eosSource(source) {
+		return make([]interface{}, 1), nil
+	}
+	a, err := sourceAs[A](source, 0)
+	if err != nil {
+		return nil, err
+	}
+	r, err := f(h, a)
+	return []interface{}{r}, err
+}
+
+func (f Func1[A, R]) portTypes() (sources, results []reflect.Type) {
+	return []reflect.Type{typeOf[A]()}, []reflect.Type{typeOf[R]()}
+}
+
+// Func2 is a func of two source values of types A and B to one result
+// value of type R.  Provide as init arg to NewHub with AllOf HubCode.
+type Func2[A, B, R any] func(h Hub, a A, b B) (R, error)
+
+// Transform calls f with its sources asserted to types A and B
+func (f Func2[A, B, R]) Transform(h Hub, source []interface{}) (result []interface{}, err error) {
+	if eosSource(source) {
+		return make([]interface{}, 1), nil
+	}
+	a, err := sourceAs[A](source, 0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := sourceAs[B](source, 1)
+	if err != nil {
+		return nil, err
+	}
+	r, err := f(h, a, b)
+	return []interface{}{r}, err
+}
+
+func (f Func2[A, B, R]) portTypes() (sources, results []reflect.Type) {
+	return []reflect.Type{typeOf[A](), typeOf[B]()}, []reflect.Type{typeOf[R]()}
+}
+
+// RetrieverFunc is a func that retrieves one value of type T.  Provide as
+// init arg to NewHub with Retrieve HubCode.
+type RetrieverFunc[T any] func(h Hub) (T, error)
+
+// Retrieve calls f, returning EOS as both result and error at end of stream
+func (f RetrieverFunc[T]) Retrieve(h Hub) (result interface{}, err error) {
+	v, err := f(h)
+	if isEOS(err) {
+		return EOS, EOS
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (f RetrieverFunc[T]) portTypes() (sources, results []reflect.Type) {
+	return nil, []reflect.Type{typeOf[T]()}
+}
+
+// portTyper is implemented by hub init args that know the types of their ports
+type portTyper interface {
+	portTypes() (sources, results []reflect.Type)
+}
+
+// portTypes are the types of the ports of a hub, nil where not known
+type portTypes struct {
+	sources []reflect.Type
+	results []reflect.Type
+}
+
+// pipeType is the type given a pipe by NewTypedPipe
+type pipeType struct {
+	p Pipe
+	t reflect.Type
+}
+
+// typeOf returns the reflect.Type of T, even for an interface type
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// sourceAs asserts a source value to type T
+func sourceAs[T any](source []interface{}, i int) (T, error) {
+	v, ok := source[i].(T)
+	if !ok {
+		return v, fmt.Errorf("source %d is %T, not %s", i, source[i], typeOf[T]())
+	}
+	return v, nil
+}
+
+// eosSource returns true if any source value is EOS
+func eosSource(source []interface{}) bool {
+	for _, v := range source {
+		if isEOS(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkValue panics if v is not nil and not a T
+func checkValue[T any](p Pipe, v interface{}) {
+	if _, ok := v.(T); !ok && v != nil {
+		panic(fmt.Sprintf("Pipe %q of type %s given value %v of type %T", p.Name(), typeOf[T](), v, v))
+	}
+}
+
+// pipeType returns the type given a pipe by NewTypedPipe, or nil
+func (fg *flowgraph) pipeType(p Pipe) reflect.Type {
+	for _, pt := range fg.ptypes {
+		if pt.p.Same(p) {
+			return pt.t
+		}
+	}
+	return nil
+}
+
+// portType returns the type of a source or result port of a node, or nil
+func (fg *flowgraph) portType(n *fgbase.Node, i int, source bool) reflect.Type {
+	pt, ok := fg.htypes[n]
+	if !ok {
+		return nil
+	}
+	types := pt.results
+	if source {
+		types = pt.sources
+	}
+	if i < 0 || i >= len(types) {
+		return nil
+	}
+	return types[i]
+}
+
+// checkSource panics if the values on pipe p can not go to source port i of h
+func (fg *flowgraph) checkSource(h Hub, i int, p Pipe) {
+	if err := fg.sourceTypeError(h, i, p); err != nil {
+		h.Panicf("%v\n", err)
+	}
+}
+
+// checkResult panics if the values from result port i of h can not go on pipe p
+func (fg *flowgraph) checkResult(h Hub, i int, p Pipe) {
+	if err := fg.resultTypeError(h, i, p); err != nil {
+		h.Panicf("%v\n", err)
+	}
+}
+
+// sourceTypeError returns an error if the values on pipe p can not go to
+// source port i of h
+func (fg *flowgraph) sourceTypeError(h Hub, i int, p Pipe) error {
+	t := fg.portType(h.Base().(*fgbase.Node), i, true)
+	if t == nil || p.Empty() {
+		return nil
+	}
+	if pt := fg.pipeType(p); pt != nil && !pt.AssignableTo(t) {
+		return fmt.Errorf("Pipe %q of type %s can not connect to source port %d of type %s on Hub %q", p.Name(), pt, i, t, h.Name())
+	}
+	for j := 0; j < p.NumUpstream(); j++ {
+		if u := p.Upstream(j); u != nil {
+			k := u.ResultIndex(p)
+			rt := fg.portType(u.Base().(*fgbase.Node), k, false)
+			if rt != nil && !rt.AssignableTo(t) {
+				return fmt.Errorf("Result port %d of type %s on Hub %q can not connect to source port %d of type %s on Hub %q", k, rt, u.Name(), i, t, h.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// resultTypeError returns an error if the values from result port i of h
+// can not go on pipe p
+func (fg *flowgraph) resultTypeError(h Hub, i int, p Pipe) error {
+	t := fg.portType(h.Base().(*fgbase.Node), i, false)
+	if t == nil || p.Empty() {
+		return nil
+	}
+	if pt := fg.pipeType(p); pt != nil && !t.AssignableTo(pt) {
+		return fmt.Errorf("Pipe %q of type %s can not connect to result port %d of type %s on Hub %q", p.Name(), pt, i, t, h.Name())
+	}
+	for j := 0; j < p.NumDownstream(); j++ {
+		if d := p.Downstream(j); d != nil {
+			k := d.SourceIndex(p)
+			st := fg.portType(d.Base().(*fgbase.Node), k, true)
+			if st != nil && !t.AssignableTo(st) {
+				return fmt.Errorf("Result port %d of type %s on Hub %q can not connect to source port %d of type %s on Hub %q", i, t, h.Name(), k, st, d.Name())
+			}
+		}
+	}
+	return nil
+}
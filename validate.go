@@ -0,0 +1,148 @@
+package flowgraph
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Validate checks the wiring of a flowgraph and every GraphHub inside it
+// before Run.  Returns every problem found joined together, or nil.
+func (fg *flowgraph) Validate() error {
+	var errs []error
+	fg.validate("", nil, &errs)
+	return errors.Join(errs...)
+}
+
+// Validate checks the wiring inside a GraphHub
+func (gh *graphhub) Validate() error {
+	var errs []error
+	gh.validate(gh.Name(), &errs)
+	return errors.Join(errs...)
+}
+
+// validate checks the hubs and pipes of a flowgraph, or of the body of gh
+// if not nil, where dangling ports are allowed and counted.  Hub names are
+// prefixed with the dotted path of GraphHubs.
+func (fg *flowgraph) validate(prefix string, gh *graphhub, errs *[]error) (ns, nr int) {
+	errorf := func(format string, v ...interface{}) {
+		*errs = append(*errs, fmt.Errorf(format, v...))
+	}
+
+	for _, c := range fg.connectErrs {
+		errorf("%s", c.message(prefix))
+	}
+
+	hubNames := make(map[string]int)
+	for _, h := range fg.hubs {
+		if hubNames[h.Name()]++; hubNames[h.Name()] == 2 {
+			errorf("hub %q: name used more than once", prefix+h.Name())
+		}
+	}
+
+	for _, h := range fg.hubs {
+		path := prefix + h.Name()
+		for i := 0; i < h.NumSource(); i++ {
+			if !h.Source(i).Empty() {
+				continue
+			}
+			if gh == nil {
+				errorf("hub %q: source port %d is not connected", path, i)
+			}
+			ns++
+		}
+		for i := 0; i < h.NumResult(); i++ {
+			if !h.Result(i).Empty() {
+				continue
+			}
+			if gh == nil {
+				errorf("hub %q: result port %d is not connected", path, i)
+			}
+			nr++
+		}
+		if inner, ok := h.(*graphhub); ok {
+			inner.validate(path, errs)
+		}
+	}
+
+	pipeNames := make(map[string]int)
+	seen := make(map[Pipe]bool)
+	for _, p := range fg.pipes {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		if p.Name() != "" {
+			if pipeNames[p.Name()]++; pipeNames[p.Name()] == 2 {
+				errorf("pipe %q: name used more than once", prefix+p.Name())
+			}
+		}
+
+		noUp := p.NumUpstream() == 0 && !p.IsConst() && !(gh != nil && exposed(gh.isources, p))
+		noDown := p.NumDownstream() == 0 && !p.IsSink() && !(gh != nil && exposed(gh.iresults, p))
+		switch {
+		case noUp && noDown:
+			errorf("pipe %s: not connected", pipeDesc(prefix, p))
+		case noUp:
+			errorf("pipe %s: no upstream hub", pipeDesc(prefix, p))
+		case noDown:
+			errorf("pipe %s: no downstream hub", pipeDesc(prefix, p))
+		}
+	}
+
+	return ns, nr
+}
+
+// validate checks a GraphHub and its body, with path its dotted name
+func (gh *graphhub) validate(path string, errs *[]error) {
+	if c := gh.HubCode(); (c == While || c == During) && gh.nbody == 0 {
+		*errs = append(*errs, fmt.Errorf("hub %q: Loop not called on %s GraphHub", path, c))
+		return
+	}
+	ns, nr := gh.fg.(*flowgraph).validate(path+".", gh, errs)
+	ns += len(gh.isources)
+	nr += len(gh.iresults)
+	if ns != gh.NumSource() {
+		*errs = append(*errs, fmt.Errorf("hub %q: # of GraphHub sources (%d) does not match # of dangling internal inputs (%d)", path, gh.NumSource(), ns))
+	}
+	if nr != gh.NumResult() {
+		*errs = append(*errs, fmt.Errorf("hub %q: # of GraphHub results (%d) does not match # of dangling internal outputs (%d)", path, gh.NumResult(), nr))
+	}
+}
+
+// connectErr is a Connect of two ports both already connected, which
+// Connect ignores
+type connectErr struct {
+	upstream     Hub
+	upstreamPort interface{}
+	dnstream     Hub
+	dnstreamPort interface{}
+}
+
+// message describes the connect with the hub names prefixed
+func (c connectErr) message(prefix string) string {
+	return fmt.Sprintf("hub %q port %v and hub %q port %v: both already connected to other pipes",
+		prefix+c.upstream.Name(), c.upstreamPort, prefix+c.dnstream.Name(), c.dnstreamPort)
+}
+
+// exposed returns true if p is one of the exposed pipes
+func exposed(pipes []Pipe, p Pipe) bool {
+	for _, q := range pipes {
+		if q.Same(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// pipeDesc names a pipe for an error, by the hub it connects if unnamed
+func pipeDesc(prefix string, p Pipe) string {
+	switch {
+	case p.Name() != "":
+		return fmt.Sprintf("%q", prefix+p.Name())
+	case p.NumUpstream() > 0:
+		return fmt.Sprintf("from hub %q", prefix+p.Upstream(0).Name())
+	case p.NumDownstream() > 0:
+		return fmt.Sprintf("to hub %q", prefix+p.Downstream(0).Name())
+	}
+	return "(unnamed)"
+}
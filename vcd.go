@@ -0,0 +1,308 @@
+package flowgraph
+
+import (
+	"github.com/vectaport/fgbase"
+
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vcdRecorder records the traffic on every pipe of a run as a Value Change
+// Dump.  Each pipe is three signals, its last value and the valid and
+// ready bits of its handshake.  Valid is set while some downstream hub has
+// yet to take a value, ready while every downstream hub has room for
+// another.  Time is nanoseconds from the start of the run, or the count of
+// firings if logical.
+type vcdRecorder struct {
+	mu      sync.Mutex
+	title   string
+	logical bool
+	start   time.Time
+	now     int64
+	pipes   []*vcdPipe
+	keys    map[pipeKey]*vcdPipe
+	changes []vcdChange
+}
+
+// vcdPipe is the handshake of one pipe
+type vcdPipe struct {
+	path     string
+	ids      [3]string // identifier codes of the value, valid, and ready signals
+	last     [3]string // last value of each signal, empty if none yet
+	capacity int
+	dsts     []*fgbase.Node
+	puts     int64
+	gets     map[*fgbase.Node]int64
+}
+
+// signals of a vcdPipe
+const (
+	vcdVal = iota
+	vcdValid
+	vcdReady
+)
+
+// vcdChange is a change of a signal at a time
+type vcdChange struct {
+	t   int64
+	id  string
+	val string
+}
+
+// newVCDRecorder readies a recorder for the nodes of a run of fg
+func (fg *flowgraph) newVCDRecorder(nodes []*fgbase.Node, logical bool) *vcdRecorder {
+	vr := &vcdRecorder{
+		title:   fg.Title(),
+		logical: logical,
+		start:   time.Now(),
+		keys:    make(map[pipeKey]*vcdPipe),
+	}
+	fg.walkPipeKeys(func(path string, k pipeKey) {
+		e := k.n.Dsts[k.i]
+		if e == nil || e.IsSink() {
+			return
+		}
+		vp := &vcdPipe{
+			path:     path,
+			capacity: k.n.Owner.(Hub).Flowgraph().(*flowgraph).capacity(e),
+			gets:     make(map[*fgbase.Node]int64),
+		}
+		for i := range vp.ids {
+			vp.ids[i] = vcdID(len(vr.pipes)*len(vp.ids) + i)
+		}
+		for i := 0; i < e.DstCnt(); i++ {
+			if d := e.DstNode(i); d != nil {
+				vp.dsts = append(vp.dsts, d)
+			}
+		}
+		vr.pipes = append(vr.pipes, vp)
+		vr.keys[k] = vp
+	})
+	for _, n := range nodes {
+		for i, e := range n.Dsts {
+			if vp := vr.keys[pipeKey{n, i}]; vp != nil && e.Val != nil {
+				vp.puts = 1 // initial value
+				vr.change(vp, vcdVal, vcdValue(e.Val))
+			}
+		}
+	}
+	for _, vp := range vr.pipes {
+		if vp.puts == 0 {
+			vr.change(vp, vcdVal, "bx")
+		}
+		vr.handshake(vp)
+	}
+	return vr
+}
+
+// fired records the values taken and put by a firing of n
+func (vr *vcdRecorder) fired(n *fgbase.Node) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+
+	if vr.logical {
+		vr.now++
+	} else if t := time.Since(vr.start).Nanoseconds(); t > vr.now {
+		vr.now = t
+	}
+
+	for _, e := range n.Srcs {
+		if e == nil || !e.Flow || e.IsConst() {
+			continue
+		}
+		k, ok := keyOf(e)
+		if !ok {
+			continue
+		}
+		if vp := vr.keys[k]; vp != nil {
+			vp.gets[n]++
+			vr.handshake(vp)
+		}
+	}
+	for i, e := range n.Dsts {
+		if e == nil || e.Val == nil {
+			continue
+		}
+		if vp := vr.keys[pipeKey{n, i}]; vp != nil {
+			vp.puts++
+			vr.change(vp, vcdVal, vcdValue(e.Val))
+			vr.handshake(vp)
+		}
+	}
+}
+
+// handshake records the valid and ready bits of a pipe
+func (vr *vcdRecorder) handshake(vp *vcdPipe) {
+	valid, ready := "0", "1"
+	for _, d := range vp.dsts {
+		held := vp.puts - vp.gets[d]
+		if held > 0 {
+			valid = "1"
+		}
+		if held >= int64(vp.capacity) {
+			ready = "0"
+		}
+	}
+	vr.change(vp, vcdValid, valid)
+	vr.change(vp, vcdReady, ready)
+}
+
+// change records a signal of a pipe taking a value now, if not the value
+// it has
+func (vr *vcdRecorder) change(vp *vcdPipe, sig int, val string) {
+	if vp.last[sig] == val {
+		return
+	}
+	vp.last[sig] = val
+	vr.changes = append(vr.changes, vcdChange{vr.now, vp.ids[sig], val})
+}
+
+// write writes the changes recorded so far as a VCD file, with a scope for
+// the flowgraph and one nested for each GraphHub
+func (vr *vcdRecorder) write(w io.Writer) error {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "$version github.com/vectaport/flowgraph $end\n")
+	if vr.logical {
+		fmt.Fprintf(b, "$comment time is the count of hub firings $end\n")
+	}
+	fmt.Fprintf(b, "$timescale 1ns $end\n")
+
+	pipes := append([]*vcdPipe(nil), vr.pipes...)
+	sort.SliceStable(pipes, func(i, j int) bool {
+		return vcdScopeLess(pipes[i].path, pipes[j].path)
+	})
+	fmt.Fprintf(b, "$scope module %s $end\n", vcdName(vr.title))
+	var scope []string
+	for _, vp := range pipes {
+		path := strings.Split(vp.path, ".")
+		name, dirs := path[len(path)-1], path[:len(path)-1]
+		same := 0
+		for same < len(scope) && same < len(dirs) && scope[same] == dirs[same] {
+			same++
+		}
+		for ; len(scope) > same; scope = scope[:len(scope)-1] {
+			fmt.Fprintf(b, "$upscope $end\n")
+		}
+		for ; len(scope) < len(dirs); scope = append(scope, dirs[len(scope)]) {
+			fmt.Fprintf(b, "$scope module %s $end\n", vcdName(dirs[len(scope)]))
+		}
+		fmt.Fprintf(b, "$var wire 64 %s %s $end\n", vp.ids[vcdVal], vcdName(name))
+		fmt.Fprintf(b, "$var wire 1 %s %s_valid $end\n", vp.ids[vcdValid], vcdName(name))
+		fmt.Fprintf(b, "$var wire 1 %s %s_ready $end\n", vp.ids[vcdReady], vcdName(name))
+	}
+	for range scope {
+		fmt.Fprintf(b, "$upscope $end\n")
+	}
+	fmt.Fprintf(b, "$upscope $end\n")
+	fmt.Fprintf(b, "$enddefinitions $end\n")
+
+	fmt.Fprintf(b, "#0\n$dumpvars\n")
+	i := 0
+	for ; i < len(vr.changes) && vr.changes[i].t == 0; i++ {
+		vr.changes[i].write(b)
+	}
+	fmt.Fprintf(b, "$end\n")
+
+	t := int64(0)
+	for _, c := range vr.changes[i:] {
+		if c.t != t {
+			t = c.t
+			fmt.Fprintf(b, "#%d\n", t)
+		}
+		c.write(b)
+	}
+	return b.Flush()
+}
+
+// vcdScopeLess orders dotted paths by the GraphHubs they are in, so each
+// scope is declared once
+func vcdScopeLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	as, bs = as[:len(as)-1], bs[:len(bs)-1]
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// write writes a change, a vector if its value is not one bit
+func (c vcdChange) write(w io.Writer) {
+	if len(c.val) == 1 {
+		fmt.Fprintf(w, "%s%s\n", c.val, c.id)
+		return
+	}
+	fmt.Fprintf(w, "b%s %s\n", c.val[1:], c.id)
+}
+
+// vcdValue returns the bits of a value as a vector, prefixed with b.  A
+// value that is not an integer or a bool, EOS for one, is unknown.
+func vcdValue(v interface{}) string {
+	var u uint64
+	switch x := v.(type) {
+	case bool:
+		if x {
+			u = 1
+		}
+	case int:
+		u = uint64(x)
+	case int8:
+		u = uint64(x)
+	case int16:
+		u = uint64(x)
+	case int32:
+		u = uint64(x)
+	case int64:
+		u = uint64(x)
+	case uint:
+		u = uint64(x)
+	case uint8:
+		u = uint64(x)
+	case uint16:
+		u = uint64(x)
+	case uint32:
+		u = uint64(x)
+	case uint64:
+		u = x
+	default:
+		return "bx"
+	}
+	return "b" + strconv.FormatUint(u, 2)
+}
+
+// vcdID returns the identifier code of the i'th signal, in the printable
+// characters from ! to ~
+func vcdID(i int) string {
+	var id []byte
+	for {
+		id = append(id, byte('!'+i%94))
+		if i /= 94; i == 0 {
+			return string(id)
+		}
+	}
+}
+
+// vcdName makes a name fit for a VCD scope or signal, with no white space
+// or brackets read as a bit select
+func vcdName(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '[', ']', ':':
+			return '_'
+		}
+		return r
+	}, s)
+}